@@ -1,191 +1,288 @@
 // Copyright (c) 2023 Roberto Meléndez.
 // Licensed under the MIT License. See the LICENSE file in the project root for license information.
 
-package main
+// Package cidr2ip expands CIDR notations into the IP addresses they contain.
+//
+// Every address of a block is included, network and broadcast addresses too.
+// Besides CIDR notation, every function accepting a CIDR also takes an
+// inclusive dash-separated range such as 10.0.0.5-10.0.0.200, a start address
+// and a count such as 10.0.0.50+20, or a bare address such as 10.0.0.5, which
+// stands for its /32 or /128.
+//
+// EachAddr and EachIP walk a block without allocating the full list, which
+// makes them the right choice for large prefixes; ExpandCIDR and ExpandCIDRs
+// return the addresses as a slice for convenience, and CIDRSeq yields them to
+// a range-over-func loop. The cidr2ip command in cmd/cidr2ip is a thin wrapper
+// around this package.
+package cidr2ip
 
 import (
-	"bufio"
-	"encoding/csv"
-	"flag"
+	"context"
 	"fmt"
+	"iter"
+	"math/big"
 	"net"
-	"os"
-	"sync"
-	"time"
+	"net/netip"
+	"strings"
 )
 
-const (
-	app     = "cidr2ip"
-	version = "1.0.0"
-)
+// ExpandCIDR returns every address in cidr as a string, in ascending order.
+func ExpandCIDR(cidr string) ([]string, error) {
+	var ips []string
 
-func main() {
-	var (
-		fileFlag    string
-		helpFlag    bool
-		versionFlag bool
-	)
+	err := EachAddr(cidr, func(addr netip.Addr) error {
+		ips = append(ips, addr.String())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	flag.StringVar(&fileFlag, "f", "", "Specify a `filename` with CIDRs")
-	flag.BoolVar(&helpFlag, "h", false, "Show help menu")
-	flag.BoolVar(&versionFlag, "v", false, "Show version")
-	flag.Parse()
+	return ips, nil
+}
 
-	if versionFlag {
-		printVersion()
-		os.Exit(0)
+// ExpandCIDRs returns the addresses of all cidrs concatenated in input order.
+// It fails on the first invalid CIDR.
+func ExpandCIDRs(cidrs []string) ([]string, error) {
+	if err := ValidateCIDRs(cidrs); err != nil {
+		return nil, err
 	}
 
-	if helpFlag {
-		printHelp()
-		os.Exit(0)
+	var ips []string
+	for _, cidr := range cidrs {
+		err := EachAddr(cidr, func(addr netip.Addr) error {
+			ips = append(ips, addr.String())
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if fileFlag == "" && flag.NArg() == 0 {
-		fmt.Fprintln(os.Stderr, "Error: No CIDRs provided. Use -h for help.")
-		os.Exit(1)
-	}
+	return ips, nil
+}
 
-	cidrs, err := readCIDRs(fileFlag)
-	handleError(err)
+// ctxCheckInterval is how many addresses ExpandCIDRsContext walks between
+// checks of its context.
+const ctxCheckInterval = 4096
 
-	ips, err := generateIPs(cidrs)
-	handleError(err)
+// ExpandCIDRsContext calls fn for every address of all cidrs, in input order,
+// like EachAddr does for a single CIDR. Every CIDR is validated before the
+// first call to fn. The walk stops at the first error returned by fn, or
+// soon after ctx is done, in which case ctx.Err() is returned.
+func ExpandCIDRsContext(ctx context.Context, cidrs []string, fn func(addr netip.Addr) error) error {
+	if err := ValidateCIDRs(cidrs); err != nil {
+		return err
+	}
 
-	file := fmt.Sprintf("%s_%s.csv", app, time.Now().Format("2006-01-02_15-04-05"))
-	err = saveToCSV(ips, file)
-	handleError(err)
+	n := 0
+	for _, cidr := range cidrs {
+		err := EachAddr(cidr, func(addr netip.Addr) error {
+			if n++; n%ctxCheckInterval == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+			return fn(addr)
+		})
+		if err != nil {
+			return err
+		}
+	}
 
-	fmt.Printf("IP list saved to %s\n", file)
+	return nil
 }
 
-func printHelp() {
-	fmt.Printf("Usage: %s [-f filename] <CIDR1 CIDR2 ...>\nOptions:\n", app)
-	flag.PrintDefaults()
-}
+// ValidateCIDRs checks that every entry in cidrs is a valid CIDR notation or
+// range and returns the error for the first one that is not, a *ParseError
+// with its position in cidrs as the Line.
+func ValidateCIDRs(cidrs []string) error {
+	for i, cidr := range cidrs {
+		if _, _, err := parseAddrRange(cidr); err != nil {
+			return atLine(err, i)
+		}
+	}
 
-func printVersion() {
-	fmt.Printf("%s version %s\n", app, version)
+	return nil
 }
 
-func readCIDRs(file string) ([]string, error) {
-	if file != "" {
-		return readFromFile(file)
+// ParseRange returns the first and last addresses covered by s, which is
+// either a CIDR notation, an inclusive range of two addresses separated by
+// a dash, a start address followed by a plus and the number of addresses, or
+// a single address. IPv4 addresses are always returned in their 4-byte form.
+func ParseRange(s string) (first, last net.IP, err error) {
+	start, end, err := parseAddrRange(s)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return flag.Args(), nil
+	return start.AsSlice(), end.AsSlice(), nil
 }
 
-func readFromFile(file string) ([]string, error) {
-	f, err := os.Open(file)
+// parseAddrRange is ParseRange with the bounds as netip addresses.
+func parseAddrRange(s string) (first, last netip.Addr, err error) {
+	first, last, err = parseNotation(s)
 	if err != nil {
-		return nil, err
+		return netip.Addr{}, netip.Addr{}, &ParseError{CIDR: s, Err: err}
 	}
-	defer f.Close()
 
-	var cidrs []string
+	return first, last, nil
+}
 
-	stat, err := f.Stat()
-	if err != nil {
-		return nil, err
+// parseNotation tells the notations parseAddrRange accepts apart and parses s
+// with the matching one.
+func parseNotation(s string) (first, last netip.Addr, err error) {
+	if start, end, ok := strings.Cut(s, "-"); ok {
+		return parseDashRange(s, start, end)
+	}
+	if start, count, ok := strings.Cut(s, "+"); ok {
+		return parseCountRange(s, start, count)
 	}
 
-	if stat.Size() == 0 {
-		return nil, fmt.Errorf("empty file: %s", file)
+	// A bare address is a single-host block, a /32 or a /128
+	if !strings.Contains(s, "/") {
+		if addr, err := netip.ParseAddr(s); err == nil && addr.Zone() == "" {
+			addr = addr.Unmap()
+			return addr, addr, nil
+		}
 	}
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		cidrs = append(cidrs, scanner.Text())
+	// net.ParseCIDR is kept for its error messages, which callers may show
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, err
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	first, _ = netip.AddrFromSlice(NetworkAddr(ipnet))
+	last, _ = netip.AddrFromSlice(BroadcastAddr(ipnet))
+	// Blocks of IPv4-mapped addresses are plain IPv4 blocks, as with net.IP
+	if first.Is4In6() && last.Is4In6() {
+		first, last = first.Unmap(), last.Unmap()
 	}
 
-	return cidrs, nil
+	return first, last, nil
 }
 
-func generateIPs(cidrs []string) ([]string, error) {
-	ipsChan := make(chan []string, len(cidrs))
-	var wg sync.WaitGroup
-
-	for _, cidr := range cidrs {
-		wg.Add(1)
-		go func(c string) {
-			defer wg.Done()
-			ipList, err := getIPsFromCIDR(c)
-			if err != nil {
-				handleError(err)
-				return
-			}
-			ipsChan <- ipList
-		}(cidr)
+func parseDashRange(s, start, end string) (first, last netip.Addr, err error) {
+	first, err1 := netip.ParseAddr(strings.TrimSpace(start))
+	last, err2 := netip.ParseAddr(strings.TrimSpace(end))
+	if err1 != nil || err2 != nil || first.Zone() != "" || last.Zone() != "" {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid IP range: %s", s)
 	}
 
-	// Start goroutine to close the channel once all workers are done
-	go func() {
-		wg.Wait()
-		close(ipsChan)
-	}()
+	first, last = first.Unmap(), last.Unmap()
+	if first.Is4() != last.Is4() {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid IP range: %s: mixes IPv4 and IPv6", s)
+	}
 
-	var ips []string
-	for ipList := range ipsChan {
-		ips = append(ips, ipList...)
+	if first.Compare(last) > 0 {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid IP range: %s: start is after end", s)
 	}
 
-	return ips, nil
+	return first, last, nil
 }
 
-func getIPsFromCIDR(cidr string) ([]string, error) {
-	ips := []string{}
-
-	ip, ipnet, err := net.ParseCIDR(cidr)
-	if err != nil {
-		return nil, err
+// parseCountRange parses a range given as its first address and the number
+// of addresses it spans, such as 10.0.0.50+20 for 10.0.0.50-10.0.0.69. The
+// count is a big.Int so it can span any IPv6 block, but the range must end
+// within the address space.
+func parseCountRange(s, start, count string) (first, last netip.Addr, err error) {
+	first, err = netip.ParseAddr(strings.TrimSpace(start))
+	if err != nil || first.Zone() != "" {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid IP range: %s", s)
 	}
+	first = first.Unmap()
 
-	for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); nextIP(ip) {
-		ips = append(ips, ip.String())
+	count = strings.TrimSpace(count)
+	n, ok := new(big.Int).SetString(count, 10)
+	if !ok || n.Sign() <= 0 || strings.HasPrefix(count, "+") {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid IP range: %s: count must be a positive integer", s)
 	}
 
-	return ips, nil
-}
-
-func nextIP(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
-		}
+	end := new(big.Int).SetBytes(first.AsSlice())
+	end.Add(end, n).Sub(end, big.NewInt(1))
+	if end.BitLen() > first.BitLen() {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid IP range: %s: runs past the end of the address space", s)
 	}
+
+	last, _ = netip.AddrFromSlice(end.FillBytes(make([]byte, first.BitLen()/8)))
+	return first, last, nil
 }
 
-func saveToCSV(ips []string, file string) error {
-	f, err := os.Create(file)
+// EachAddr calls fn for every address in cidr, in ascending order, without
+// building the whole list in memory. It stops at the first error returned by
+// fn and returns that error. Addresses are values, so fn may keep them.
+func EachAddr(cidr string, fn func(addr netip.Addr) error) error {
+	addr, last, err := parseAddrRange(cidr)
 	if err != nil {
 		return err
 	}
 
-	buf := bufio.NewWriter(f)
-	defer buf.Flush()
-
-	w := csv.NewWriter(buf)
-	defer w.Flush()
+	for {
+		if err := fn(addr); err != nil {
+			return err
+		}
+		// Stopping at last, rather than when Next runs out, also ends ranges
+		// that stop short of the top of the address space
+		if addr == last {
+			return nil
+		}
+		addr = addr.Next()
+	}
+}
 
-	for _, ip := range ips {
-		err := w.Write([]string{ip})
+// CIDRSeq returns an iterator over every address in cidr, in ascending order,
+// for use in a range-over-func loop:
+//
+//	for addr, err := range cidr2ip.CIDRSeq("10.0.0.0/24") {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// Addresses are produced lazily, so breaking out of the loop early is cheap
+// whatever the size of the block. If cidr is invalid, the only pair yielded
+// holds the zero Addr and the parse error.
+func CIDRSeq(cidr string) iter.Seq2[netip.Addr, error] {
+	return func(yield func(netip.Addr, error) bool) {
+		addr, last, err := parseAddrRange(cidr)
 		if err != nil {
-			return err
+			yield(netip.Addr{}, err)
+			return
+		}
+
+		for {
+			if !yield(addr, nil) || addr == last {
+				return
+			}
+			addr = addr.Next()
 		}
 	}
+}
 
-	return nil
+// EachIP is like EachAddr, but passes each address as a net.IP. The ip passed
+// to fn is reused between calls, so fn must copy it if it needs to keep it.
+func EachIP(cidr string, fn func(ip net.IP) error) error {
+	var ip net.IP
+
+	return EachAddr(cidr, func(addr netip.Addr) error {
+		if addr.Is4() {
+			a := addr.As4()
+			ip = append(ip[:0], a[:]...)
+		} else {
+			a := addr.As16()
+			ip = append(ip[:0], a[:]...)
+		}
+		return fn(ip)
+	})
 }
 
-func handleError(err error) {
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+func nextIP(ip net.IP) {
+	for j := len(ip) - 1; j >= 0; j-- {
+		ip[j]++
+		if ip[j] > 0 {
+			break
+		}
 	}
 }
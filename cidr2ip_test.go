@@ -1,160 +1,473 @@
 // Copyright (c) 2023 Roberto Meléndez.
 // Licensed under the MIT License. See the LICENSE file in the project root for license information.
 
-package main
+package cidr2ip
 
 import (
-	"log"
-	"os"
-	"os/exec"
-	"regexp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
 	"strings"
 	"testing"
 )
 
-const binPath = "./cidr2ip"
+func TestExpandCIDR(t *testing.T) {
+	ips, err := ExpandCIDR("192.168.1.0/30")
+	if err != nil {
+		t.Fatalf("ExpandCIDR failed with error: %v", err)
+	}
 
-func TestCmdArguments(t *testing.T) {
-	buildBinary(t)
+	expected := []string{"192.168.1.0", "192.168.1.1", "192.168.1.2", "192.168.1.3"}
+	if strings.Join(ips, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected %v, got %v instead.", expected, ips)
+	}
 
-	// Test with a single CIDR as a command-line argument
-	file1 := checkCmdOutput(t, binPath, "10.0.0.0/24")
+	// Test with an IPv6 block
+	ips, err = ExpandCIDR("2001:db8::fffc/126")
+	if err != nil {
+		t.Fatalf("ExpandCIDR failed with error: %v", err)
+	}
 
-	// Test with multiple CIDRs as command-line arguments
-	file2 := checkCmdOutput(t, binPath, "10.0.1.0/24", "172.16.16.0/20", "192.168.0.0/16")
+	expected = []string{"2001:db8::fffc", "2001:db8::fffd", "2001:db8::fffe", "2001:db8::ffff"}
+	if strings.Join(ips, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected %v, got %v instead.", expected, ips)
+	}
 
-	// Test with no CIDRs provided
-	checkError(t, binPath)
+	// Test with an invalid CIDR
+	if _, err := ExpandCIDR("192.168.1.0/33"); err == nil {
+		t.Error("Expected an error, but ExpandCIDR succeeded.")
+	}
+}
 
-	if file1 == file2 {
-		removeFiles(t, file1)
-	} else {
-		removeFiles(t, file1, file2)
+func TestSmallPrefixes(t *testing.T) {
+	tests := []struct {
+		cidr  string
+		count int
+	}{
+		{"10.0.0.0/30", 4},
+		{"10.0.0.0/31", 2},
+		{"10.0.0.0/32", 1},
+		{"10.0.0.1/31", 2},
+		{"2001:db8::/126", 4},
+		{"2001:db8::/127", 2},
+		{"2001:db8::/128", 1},
+	}
+
+	// Test that the smallest blocks keep every address, network and
+	// broadcast included, and that a single host is never left empty
+	for _, tt := range tests {
+		ips, err := ExpandCIDR(tt.cidr)
+		if err != nil {
+			t.Fatalf("ExpandCIDR(%s) failed with error: %v", tt.cidr, err)
+		}
+		if len(ips) != tt.count {
+			t.Errorf("ExpandCIDR(%s): expected %d addresses, got %d instead: %v", tt.cidr, tt.count, len(ips), ips)
+		}
 	}
 }
 
-func TestFileInput(t *testing.T) {
-	buildBinary(t)
+func TestTopOfAddressSpace(t *testing.T) {
+	tests := []struct {
+		cidr     string
+		expected []string
+	}{
+		{"255.255.255.252/30", []string{"255.255.255.252", "255.255.255.253", "255.255.255.254", "255.255.255.255"}},
+		{"255.255.255.255/32", []string{"255.255.255.255"}},
+		{"255.255.255.254-255.255.255.255", []string{"255.255.255.254", "255.255.255.255"}},
+		{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe/127", []string{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"}},
+	}
 
-	// Test with a sample CIDR file from the repository
-	file1 := checkCmdOutput(t, binPath, "-f", "cidrs")
+	// Test that the walk ends right after the last address instead of
+	// wrapping around to 0.0.0.0, bailing out as soon as it goes too far
+	errTooMany := errors.New("too many addresses")
+	for _, tt := range tests {
+		var ips []string
+		err := EachIP(tt.cidr, func(ip net.IP) error {
+			if len(ips) == len(tt.expected) {
+				return errTooMany
+			}
+			ips = append(ips, ip.String())
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("EachIP(%s) failed with error: %v", tt.cidr, err)
+		}
+		if strings.Join(ips, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("EachIP(%s): expected %v, got %v instead.", tt.cidr, tt.expected, ips)
+		}
+	}
+}
 
-	// Test with a non-existent file
-	checkError(t, binPath, "-f", "nonexistent_file.txt")
+func TestExpandCIDRs(t *testing.T) {
+	ips, err := ExpandCIDRs([]string{"10.0.0.2/31", "10.0.0.0/31"})
+	if err != nil {
+		t.Fatalf("ExpandCIDRs failed with error: %v", err)
+	}
 
-	// Test with an empty file
-	file2 := "empty_file.txt"
-	if err := createEmptyFile(file2); err != nil {
-		t.Fatalf("Failed to create empty file: %v", err)
+	// Test that blocks are concatenated in input order
+	expected := []string{"10.0.0.2", "10.0.0.3", "10.0.0.0", "10.0.0.1"}
+	if strings.Join(ips, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected %v, got %v instead.", expected, ips)
 	}
-	checkError(t, binPath, "-f", file2)
 
-	removeFiles(t, file1, file2)
+	// Test with one invalid CIDR among valid ones
+	if _, err := ExpandCIDRs([]string{"10.0.0.0/24", "172.256.0.0/16"}); err == nil {
+		t.Error("Expected an error, but ExpandCIDRs succeeded.")
+	}
 }
 
-func TestInvalidCIDRs(t *testing.T) {
-	buildBinary(t)
-
-	// Test with an invalid CIDR as a command-line argument
-	checkError(t, binPath, "10.0.0.0/33")
+func TestExpandCIDRsContext(t *testing.T) {
+	// Test that every address is walked when the context is never done
+	var ips []string
+	err := ExpandCIDRsContext(context.Background(), []string{"10.0.0.2/31", "10.0.0.0/31"}, func(addr netip.Addr) error {
+		ips = append(ips, addr.String())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExpandCIDRsContext failed with error: %v", err)
+	}
+	if strings.Join(ips, " ") != "10.0.0.2 10.0.0.3 10.0.0.0 10.0.0.1" {
+		t.Errorf("Expected [10.0.0.2 10.0.0.3 10.0.0.0 10.0.0.1], got %v instead.", ips)
+	}
 
-	// Test with multiple CIDRs as command-line arguments, one of which is invalid
-	checkError(t, binPath, "10.0.0.0/24", "172.256.0.0/16", "192.168.0.0/16")
+	// Test that cancelling mid-expansion stops the walk promptly
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	// Test with a file containing one invalid CIDR
-	file := "invalid_cidr.txt"
-	if err := os.WriteFile(file, []byte("192.168.1.0/16.0"), 0644); err != nil {
-		t.Fatalf("Failed to create invalid CIDR file: %v", err)
+	calls := 0
+	err = ExpandCIDRsContext(ctx, []string{"10.0.0.0/8"}, func(addr netip.Addr) error {
+		if calls++; calls == 1000 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected error %v, got %v instead.", context.Canceled, err)
+	}
+	if calls > 1000+ctxCheckInterval {
+		t.Errorf("Expected the walk to stop soon after cancelling, got %d calls instead.", calls)
 	}
-	checkError(t, binPath, "-f", file)
 
-	removeFiles(t, file)
+	// Test that a done context doesn't hide an invalid CIDR
+	if err := ExpandCIDRsContext(ctx, []string{"10.0.0.0/33"}, func(netip.Addr) error { return nil }); errors.Is(err, context.Canceled) || err == nil {
+		t.Errorf("Expected a parse error, got %v instead.", err)
+	}
 }
 
-func TestIPCount(t *testing.T) {
-	buildBinary(t)
+func TestEachIP(t *testing.T) {
+	tests := []struct {
+		cidr     string
+		expected []string
+	}{
+		{"10.0.0.0/30", []string{"10.0.0.0", "10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{"10.0.0.7/32", []string{"10.0.0.7"}},
+		{"10.0.0.7", []string{"10.0.0.7"}},
+		{"2001:db8::7", []string{"2001:db8::7"}},
+		{"10.0.0.254-10.0.1.1", []string{"10.0.0.254", "10.0.0.255", "10.0.1.0", "10.0.1.1"}},
+		{"2001:db8::fffe-2001:db8::1:0", []string{"2001:db8::fffe", "2001:db8::ffff", "2001:db8::1:0"}},
+	}
 
-	// Test with a single CIDR as a command-line argument
-	file := checkCmdOutput(t, binPath, "172.16.18.0/20")
+	// Test that each address is seen exactly once, in order
+	for _, tt := range tests {
+		var ips []string
+		err := EachIP(tt.cidr, func(ip net.IP) error {
+			ips = append(ips, ip.String())
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("EachIP(%s) failed with error: %v", tt.cidr, err)
+		}
+		if strings.Join(ips, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("EachIP(%s): expected %v, got %v instead.", tt.cidr, tt.expected, ips)
+		}
+	}
 
-	data, err := os.ReadFile(file)
+	// Test that an error from fn stops the walk early
+	errStop := errors.New("stop")
+	calls := 0
+	err := EachIP("10.0.0.0/8", func(ip net.IP) error {
+		calls++
+		if calls == 3 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Errorf("Expected error %v, got %v instead.", errStop, err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 calls before stopping, got %d instead.", calls)
+	}
+
+	// Test with an invalid CIDR
+	if err := EachIP("10.0.0.0/33", func(net.IP) error { return nil }); err == nil {
+		t.Error("Expected an error, but EachIP succeeded.")
+	}
+}
+
+func TestEachAddr(t *testing.T) {
+	// Test that addresses can be kept without copying
+	var addrs []netip.Addr
+	err := EachAddr("10.0.0.254-10.0.1.1", func(addr netip.Addr) error {
+		addrs = append(addrs, addr)
+		return nil
+	})
 	if err != nil {
-		t.Fatalf("Error reading file: %v", err)
+		t.Fatalf("EachAddr failed with error: %v", err)
 	}
 
-	count := strings.Count(string(data), "\n")
+	expected := "[10.0.0.254 10.0.0.255 10.0.1.0 10.0.1.1]"
+	if got := fmt.Sprint(addrs); got != expected {
+		t.Errorf("Expected %s, got %s instead.", expected, got)
+	}
 
-	expected := 4096
-	if count != expected {
-		t.Errorf("Expected %d IP addresses, but found %d", expected, count)
+	// Test that IPv4-mapped blocks come out as plain IPv4, like net.IP prints them
+	ips, err := ExpandCIDR("::ffff:10.0.0.0/127")
+	if err != nil {
+		t.Fatalf("ExpandCIDR failed with error: %v", err)
+	}
+	if strings.Join(ips, " ") != "10.0.0.0 10.0.0.1" {
+		t.Errorf("Expected [10.0.0.0 10.0.0.1], got %v instead.", ips)
 	}
 
-	removeFiles(t, file)
+	// Test that zoned addresses are rejected in ranges
+	if err := EachAddr("fe80::1%eth0-fe80::2", func(netip.Addr) error { return nil }); err == nil {
+		t.Error("Expected an error, but EachAddr succeeded.")
+	}
 }
 
-func buildBinary(t *testing.T) {
-	cmd := exec.Command("go", "build", ".")
-	if err := cmd.Run(); err != nil {
-		t.Fatalf("Failed to build binary: %v", err)
+func TestCIDRSeq(t *testing.T) {
+	// Test that the iterator walks the same addresses as EachAddr
+	var ips []string
+	for addr, err := range CIDRSeq("255.255.255.253-255.255.255.255") {
+		if err != nil {
+			t.Fatalf("CIDRSeq failed with error: %v", err)
+		}
+		ips = append(ips, addr.String())
+	}
+	if strings.Join(ips, " ") != "255.255.255.253 255.255.255.254 255.255.255.255" {
+		t.Errorf("Expected [255.255.255.253 255.255.255.254 255.255.255.255], got %v instead.", ips)
+	}
+
+	// Test that breaking out of a huge block stops the walk
+	n := 0
+	for range CIDRSeq("2001:db8::/32") {
+		if n++; n == 3 {
+			break
+		}
+	}
+	if n != 3 {
+		t.Errorf("Expected 3 iterations, got %d instead.", n)
+	}
+
+	// Test that an invalid CIDR yields its error once, with the zero Addr
+	n = 0
+	for addr, err := range CIDRSeq("10.0.0.0/33") {
+		n++
+		if err == nil || addr.IsValid() {
+			t.Errorf("Expected an error and the zero Addr, got %v, %v instead.", addr, err)
+		}
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 iteration, got %d instead.", n)
 	}
 }
 
-func checkCmdOutput(t *testing.T, b string, args ...string) string {
-	output, err := runCommand(b, args...)
-	if err != nil {
-		t.Fatalf("Command failed with error: %v", err)
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		input       string
+		first, last string
+	}{
+		{"10.0.0.0/24", "10.0.0.0", "10.0.0.255"},
+		{"10.0.0.5/24", "10.0.0.0", "10.0.0.255"},
+		{"10.0.0.5-10.0.0.200", "10.0.0.5", "10.0.0.200"},
+		{"10.0.0.5 - 10.0.0.5", "10.0.0.5", "10.0.0.5"},
+		{"2001:db8::/126", "2001:db8::", "2001:db8::3"},
+		{"10.0.0.5", "10.0.0.5", "10.0.0.5"},
+		{"2001:db8::5", "2001:db8::5", "2001:db8::5"},
+		{"10.0.0.50+20", "10.0.0.50", "10.0.0.69"},
+		{"10.0.0.250 + 10", "10.0.0.250", "10.0.1.3"},
+		{"10.0.0.5+1", "10.0.0.5", "10.0.0.5"},
+		{"255.255.255.0+256", "255.255.255.0", "255.255.255.255"},
+		{"2001:db8::fffe+4", "2001:db8::fffe", "2001:db8::1:1"},
+		{"::+340282366920938463463374607431768211456", "::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
 	}
 
-	expected := "IP list saved to cidr2ip_"
-	if !strings.Contains(string(output), expected) {
-		t.Errorf("Expected '%s', got '%s' instead.", expected, output)
+	for _, tt := range tests {
+		first, last, err := ParseRange(tt.input)
+		if err != nil {
+			t.Fatalf("ParseRange(%s) failed with error: %v", tt.input, err)
+		}
+		if first.String() != tt.first || last.String() != tt.last {
+			t.Errorf("ParseRange(%s): expected %s-%s, got %s-%s instead.", tt.input, tt.first, tt.last, first, last)
+		}
 	}
 
-	return extractFileName(output, "IP list saved to (.+\\.csv)")
+	// Test with invalid ranges
+	for _, input := range []string{
+		"10.0.0.200-10.0.0.5",
+		"10.0.0.1-2001:db8::1",
+		"10.0.0.1-",
+		"10.0.0.1-10.0.0.256",
+		"10.0.0.256",
+		"fe80::1%eth0",
+		"10.0.0.5+0",
+		"10.0.0.5+-1",
+		"10.0.0.5++1",
+		"10.0.0.5+x",
+		"10.0.0.5+",
+		"255.255.255.0+257",
+		"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff+2",
+	} {
+		if _, _, err := ParseRange(input); err == nil {
+			t.Errorf("ParseRange(%s): expected an error, but it succeeded.", input)
+		}
+	}
 }
 
-func runCommand(b string, args ...string) (string, error) {
-	cmd := exec.Command(b, args...)
-	output, err := cmd.CombinedOutput()
-	return string(output), err
+func TestParseError(t *testing.T) {
+	// Test that a single entry fails with no line
+	_, _, err := ParseRange("10.0.0.0/33")
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("Expected a *ParseError, got %T instead: %v", err, err)
+	}
+	if pe.CIDR != "10.0.0.0/33" || pe.Line != 0 {
+		t.Errorf("Expected 10.0.0.0/33 at line 0, got %q at line %d instead.", pe.CIDR, pe.Line)
+	}
+	if err.Error() != "invalid CIDR address: 10.0.0.0/33" {
+		t.Errorf("Expected the message of the underlying error, got %q instead.", err)
+	}
+	var netErr *net.ParseError
+	if !errors.As(err, &netErr) {
+		t.Errorf("Expected the underlying *net.ParseError to be unwrapped, got %v instead.", err)
+	}
+
+	// Test that the list functions recover the position of the entry
+	cidrs := []string{"10.0.0.0/24", "10.0.1.0/24", "10.0.0.300", "10.0.2.0/24"}
+	for name, fn := range map[string]func() error{
+		"ValidateCIDRs": func() error { return ValidateCIDRs(cidrs) },
+		"ExpandCIDRs":   func() error { _, err := ExpandCIDRs(cidrs); return err },
+		"MergeCIDRs":    func() error { _, err := MergeCIDRs(cidrs); return err },
+		"DedupCIDRs":    func() error { _, err := DedupCIDRs(cidrs); return err },
+		"FindOverlaps":  func() error { _, err := FindOverlaps(cidrs); return err },
+	} {
+		err := fmt.Errorf("wrapped: %w", fn())
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Errorf("%s: expected a *ParseError, got %v instead.", name, err)
+			continue
+		}
+		if pe.Line != 3 || pe.CIDR != "10.0.0.300" {
+			t.Errorf("%s: expected 10.0.0.300 at line 3, got %q at line %d instead.", name, pe.CIDR, pe.Line)
+		}
+		if !strings.HasPrefix(pe.Error(), "line 3: ") {
+			t.Errorf("%s: expected the message to start with the line, got %q instead.", name, pe.Error())
+		}
+	}
 }
 
-func extractFileName(output, pattern string) string {
-	re := regexp.MustCompile(pattern)
-	match := re.FindStringSubmatch(output)
-	if len(match) != 2 {
-		log.Fatalf("Failed to extract filename from output: %s", output)
+// The benchmarks below compare walking a /16 with the original net.IP loop,
+// copied here as eachIPSlices since EachIP now wraps EachAddr, with EachIP
+// and EachAddr as they are.
+
+// eachIPSlices is EachIP as it was before EachAddr: it steps a reused net.IP
+// with nextIP until it equals the last address.
+func eachIPSlices(cidr string, fn func(ip net.IP) error) error {
+	ip, last, err := ParseRange(cidr)
+	if err != nil {
+		return err
 	}
 
-	return match[1]
+	for {
+		if err := fn(ip); err != nil {
+			return err
+		}
+		if ip.Equal(last) {
+			return nil
+		}
+		nextIP(ip)
+	}
 }
 
-func removeFiles(t *testing.T, files ...string) {
-	for _, file := range files {
-		if err := os.Remove(file); err != nil {
-			t.Logf("Error removing file: %v", err)
+func BenchmarkEachIPSlicesSlash16(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := eachIPSlices("10.0.0.0/16", func(ip net.IP) error {
+			_ = ip.String()
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
 		}
 	}
+}
 
-	if err := os.Remove(binPath); err != nil {
-		t.Logf("Failed to remove binary file: %v", err)
+func BenchmarkEachIPSlash16(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := EachIP("10.0.0.0/16", func(ip net.IP) error {
+			_ = ip.String()
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
 	}
 }
 
-func createEmptyFile(file string) error {
-	f, err := os.Create(file)
-	if err != nil {
-		return err
+func BenchmarkEachAddrSlash16(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := EachAddr("10.0.0.0/16", func(addr netip.Addr) error {
+			_ = addr.String()
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// The benchmarks below track the core expansion paths on their own, away
+// from flag parsing and output formatting, reporting addresses per second
+// alongside the allocations per op.
+
+func BenchmarkExpandSlash16(b *testing.B) {
+	b.ReportAllocs()
+	n := 0
+	for i := 0; i < b.N; i++ {
+		ips, err := ExpandCIDR("10.0.0.0/16")
+		if err != nil {
+			b.Fatal(err)
+		}
+		n += len(ips)
 	}
-	defer f.Close()
+	b.ReportMetric(float64(n)/b.Elapsed().Seconds(), "addrs/s")
+}
 
-	return nil
+func BenchmarkExpandSlash8Streaming(b *testing.B) {
+	b.ReportAllocs()
+	n := 0
+	for i := 0; i < b.N; i++ {
+		err := EachAddr("10.0.0.0/8", func(addr netip.Addr) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(n)/b.Elapsed().Seconds(), "addrs/s")
 }
 
-func checkError(t *testing.T, b string, args ...string) {
-	cmd := exec.Command(b, args...)
-	err := cmd.Run()
-	if err == nil {
-		t.Error("Expected an error, but command succeeded.")
+func BenchmarkNextIP(b *testing.B) {
+	b.ReportAllocs()
+	ip := net.ParseIP("10.0.0.0").To4()
+	for i := 0; i < b.N; i++ {
+		nextIP(ip)
 	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "addrs/s")
 }
@@ -0,0 +1,285 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rcmelendez/cidr2ip"
+)
+
+// batchConfig is the -batch file: a list of named jobs, each writing the IPs
+// of its own CIDRs to its own file.
+type batchConfig struct {
+	Jobs []batchJob `json:"jobs"`
+}
+
+// batchJob is a single run of a -batch file. Its CIDRs are those listed
+// followed by those of its files, as with -f and arguments. Format defaults to
+// -format, and every other option is taken from the command line, but for
+// those batchExclusive names. Relative files and outputs are relative to the
+// -batch file.
+type batchJob struct {
+	Name   string   `json:"name"`
+	CIDRs  []string `json:"cidrs"`
+	Files  []string `json:"files"`
+	Output string   `json:"output"`
+	Format string   `json:"format"`
+}
+
+// batchExclusive lists the flags -batch can't be combined with: those picking
+// another mode of the run, reporting on it as a whole, or placing its output,
+// which every job names itself.
+var batchExclusive = []string{
+	"f", "o", "outdir", "split-files", "count", "count-per-cidr", "prefix-summary", "unique-count",
+	"dry-run", "check", "wildcard", "contains", "collapse", "split", "json-summary",
+}
+
+// checkBatchFlags fails if any of the flags set on the command line can't be
+// used with -batch, and so would be ignored.
+func checkBatchFlags(set *flag.FlagSet) error {
+	var used []string
+	set.Visit(func(f *flag.Flag) {
+		if slices.Contains(batchExclusive, f.Name) {
+			used = append(used, "-"+f.Name)
+		}
+	})
+	if len(used) > 0 {
+		return withCode(exitUsage, fmt.Errorf("-batch can't be used with %s", strings.Join(used, ", ")))
+	}
+
+	return nil
+}
+
+// readBatch parses the -batch file and checks every job is complete before
+// any of them runs.
+func readBatch(file string) (*batchConfig, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var config batchConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&config); err != nil {
+		return nil, withCode(exitUsage, fmt.Errorf("%s: %w", file, err))
+	}
+	if len(config.Jobs) == 0 {
+		return nil, withCode(exitUsage, fmt.Errorf("%s: no jobs", file))
+	}
+
+	seen := make(map[string]bool)
+	for i, job := range config.Jobs {
+		switch {
+		case job.Name == "":
+			return nil, withCode(exitUsage, fmt.Errorf("%s: job %d has no name", file, i+1))
+		case seen[job.Name]:
+			return nil, withCode(exitUsage, fmt.Errorf("%s: job %q is repeated", file, job.Name))
+		case len(job.CIDRs) == 0 && len(job.Files) == 0:
+			return nil, withCode(exitUsage, fmt.Errorf("%s: job %q has no cidrs or files", file, job.Name))
+		case job.Output == "":
+			return nil, withCode(exitUsage, fmt.Errorf("%s: job %q has no output", file, job.Name))
+		case job.Format != "" && formats[job.Format] == nil:
+			return nil, withCode(exitUsage, fmt.Errorf("%s: job %q has invalid format %q: use one of %s", file, job.Name, job.Format, formatNames()))
+		}
+		seen[job.Name] = true
+	}
+
+	dir := filepath.Dir(file)
+	for i := range config.Jobs {
+		job := &config.Jobs[i]
+		for j, f := range job.Files {
+			job.Files[j] = relativeTo(dir, f)
+		}
+		if !isStdout(job.Output) {
+			job.Output = relativeTo(dir, job.Output)
+		}
+	}
+
+	return &config, nil
+}
+
+// relativeTo returns file as seen from dir, unless it is absolute or a URL.
+func relativeTo(dir, file string) string {
+	if isURL(file) || filepath.IsAbs(file) {
+		return file
+	}
+
+	return filepath.Join(dir, file)
+}
+
+// runBatch runs every job of the -batch file, one after the other, with the
+// options of opts. A failing job is reported on stderr and the next one still
+// runs; the error returned then carries the exit code of the first failure.
+func runBatch(file string, opts *options) error {
+	config, err := readBatch(file)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if opts.verbose {
+		opts.logger = newCIDRLogger(os.Stderr, time.Now())
+	}
+
+	var failed []error
+	for _, job := range config.Jobs {
+		if ctx.Err() != nil {
+			failed = append(failed, withCode(exitInterrupted, fmt.Errorf("job %s: interrupted before it started", job.Name)))
+			break
+		}
+
+		written, err := runJob(ctx, job, opts)
+		if err != nil {
+			err = fmt.Errorf("job %s: %w", job.Name, err)
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			failed = append(failed, err)
+			continue
+		}
+		if !isStdout(written) && !opts.quiet {
+			fmt.Printf("%s: IP list saved to %s\n", job.Name, written)
+		}
+	}
+
+	if len(failed) > 0 {
+		return withCode(exitCode(failed[0]), fmt.Errorf("%d of %d job(s) failed", len(failed), len(config.Jobs)))
+	}
+
+	return nil
+}
+
+// runJob writes the IPs of job with opts and returns the name of the file
+// written. The input is checked as main checks that of a single run: -family
+// filters it, and -no-dup-cidrs, -warn-overlap and -keep-going apply, each to
+// the job alone. With -stats, its figures are printed once it is written.
+func runJob(ctx context.Context, job batchJob, opts *options) (string, error) {
+	start := time.Now()
+	o := *opts
+	o.output = job.Output
+	if job.Format != "" {
+		if job.Format == "template" && o.tmpl == nil {
+			return "", withCode(exitUsage, errors.New("the template format requires -template"))
+		}
+		o.format = job.Format
+		// The default columns depend on the format
+		columns, err := parseColumns(&o)
+		if err != nil {
+			return "", withCode(exitUsage, err)
+		}
+		o.columnList = columns
+	}
+
+	// -append applies to the file each job names, in the format it writes
+	if o.appendOutput {
+		switch {
+		case isStdout(o.output):
+			return "", withCode(exitUsage, errors.New("-append requires an output file"))
+		case o.format == "json" || o.format == "parquet":
+			return "", withCode(exitUsage, fmt.Errorf("-append can't be used with the %s format", o.format))
+		}
+	}
+
+	var inputs []cidrInput
+	for i, cidr := range job.CIDRs {
+		inputs = append(inputs, cidrInput{cidr: cidr, source: "cidrs", line: i + 1})
+	}
+	for _, f := range job.Files {
+		read, err := readSource(f, o.timeout)
+		if err != nil {
+			return "", err
+		}
+		inputs = append(inputs, read...)
+	}
+
+	if o.family != 0 {
+		var skipped int
+		inputs, skipped = filterFamily(inputs, o.family)
+		if skipped > 0 && !o.quiet {
+			warnf("job %s: skipped %d CIDR(s) not of IPv%d", job.Name, skipped, o.family)
+		}
+		if len(inputs) == 0 {
+			return "", withCode(exitUsage, fmt.Errorf("no IPv%d CIDRs in the job", o.family))
+		}
+	}
+
+	if o.noDupCIDRs {
+		if dups := findDupCIDRs(inputs); len(dups) > 0 {
+			if !o.warn {
+				return "", withCode(exitParse, fmt.Errorf("%d duplicate CIDR(s): %s", len(dups), strings.Join(dups, "; ")))
+			}
+			for _, dup := range dups {
+				warnf("job %s: duplicate CIDR: %s", job.Name, dup)
+			}
+		}
+	}
+
+	cidrs, invalid := checkCIDRs(inputs, o.strict)
+	if len(invalid) > 0 && !o.keepGoing {
+		return "", errors.Join(invalid...)
+	}
+
+	if o.warnOverlap {
+		overlaps, err := cidr2ip.FindOverlaps(cidrs)
+		if err != nil {
+			return "", withCode(exitParse, err)
+		}
+		for _, pair := range overlaps {
+			warnf("job %s: %s overlaps %s", job.Name, pair[0], pair[1])
+		}
+	}
+
+	total, err := expectedIPs(cidrs, &o)
+	if err != nil {
+		return "", err
+	}
+	if err := checkMaxIPs(total, &o); err != nil {
+		return "", err
+	}
+	o.total = total
+
+	file := o.output
+	if !isStdout(file) {
+		if file, err = filepath.Abs(file); err != nil {
+			return "", err
+		}
+	}
+
+	var st *runStats
+	if o.stats {
+		st = &runStats{start: start, cidrs: len(cidrs)}
+	}
+	if len(cidrs) > 0 {
+		if file, err = writeOutput(ctx, cidrs, file, &o, st); err != nil {
+			return "", err
+		}
+		if st != nil {
+			fmt.Fprintf(os.Stderr, "%s:\n", job.Name)
+			if err := st.print(os.Stderr); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	// The valid CIDRs are written, but the job still fails over the others
+	if len(invalid) > 0 {
+		return file, errors.Join(invalid...)
+	}
+
+	return file, nil
+}
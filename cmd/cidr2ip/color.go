@@ -0,0 +1,46 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ANSI SGR codes of the colors used on stderr.
+const (
+	colorYellow = "33"
+	colorCyan   = "36"
+)
+
+// stderrColor reports, once for the whole run, whether what is written to
+// stderr may be colored. Every colored message goes through colorize, so this
+// is the only place deciding it.
+var stderrColor = sync.OnceValue(func() bool {
+	return shouldColor(os.Getenv("NO_COLOR"), isTerminal(os.Stderr))
+})
+
+// shouldColor reports whether to color output going to a terminal if tty is
+// set, given the value of NO_COLOR. As https://no-color.org asks, any value
+// but the empty string turns colors off, and pipes and files never get them.
+func shouldColor(noColor string, tty bool) bool {
+	return tty && noColor == ""
+}
+
+// colorize returns s wrapped in the escape sequences of the color code when
+// stderr may be colored, and s alone otherwise.
+func colorize(s, code string) string {
+	if !stderrColor() {
+		return s
+	}
+
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
+
+// warnf prints a warning to stderr, its "Warning:" label highlighted when
+// stderr may be colored.
+func warnf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "%s %s\n", colorize("Warning:", colorYellow), fmt.Sprintf(format, args...))
+}
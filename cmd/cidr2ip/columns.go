@@ -0,0 +1,238 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"math/bits"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// columnNames lists the output columns -columns can select from.
+var columnNames = []string{"ip", "cidr", "prefix", "offset", "int", "hex", "mapped", "class", "ptr"}
+
+// parseColumns returns the output columns selected in opts. Without -columns
+// they are the ip column followed by those -with-cidr, -int and -resolve add,
+// in that order, and for the template format those its fields refer to.
+// Unknown and repeated names are rejected, and so is a list without the ip
+// column, which every format relies on.
+func parseColumns(opts *options) ([]string, error) {
+	if opts.columns == "" {
+		columns := []string{"ip"}
+		if opts.withCIDR {
+			columns = append(columns, "cidr")
+		}
+		if opts.intColumn {
+			columns = append(columns, "int")
+		}
+		if opts.resolve {
+			columns = append(columns, "ptr")
+		}
+		if opts.format == "template" && opts.tmpl != nil {
+			for _, name := range templateColumns(opts.tmpl) {
+				if !slices.Contains(columns, name) {
+					columns = append(columns, name)
+				}
+			}
+		}
+		return columns, nil
+	}
+
+	if opts.withCIDR || opts.intColumn || opts.resolve {
+		return nil, fmt.Errorf("-columns can't be used with -with-cidr, -int or -resolve; list their columns instead")
+	}
+
+	var columns []string
+	for _, name := range strings.Split(opts.columns, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(columnNames, name) {
+			return nil, fmt.Errorf("unknown column %q in -columns: use %s", name, strings.Join(columnNames, ", "))
+		}
+		if slices.Contains(columns, name) {
+			return nil, fmt.Errorf("column %q is repeated in -columns", name)
+		}
+		columns = append(columns, name)
+	}
+	if !slices.Contains(columns, "ip") {
+		return nil, fmt.Errorf("-columns must include the ip column")
+	}
+
+	return columns, nil
+}
+
+// newRowBuilder returns a function building the output row of addr, which
+// comes from the CIDR at index i, with one field per column. sources holds
+// the entry reported in the cidr column for each index. The ptr column is
+// left empty for the resolver to fill in.
+func newRowBuilder(columns, sources []string) func(i int, addr netip.Addr) []string {
+	// The prefix and first address of a source are the same for all its IPs,
+	// so they are worked out once
+	var prefixes []string
+	if slices.Contains(columns, "prefix") {
+		prefixes = make([]string, len(sources))
+		for i, source := range sources {
+			prefixes[i] = prefixLength(source)
+		}
+	}
+	var firsts []netip.Addr
+	if slices.Contains(columns, "offset") {
+		firsts = make([]netip.Addr, len(sources))
+		for i, source := range sources {
+			// Sources were all validated before the expansion started
+			r, _ := parseAddrRange(source)
+			firsts[i] = r.first
+		}
+	}
+
+	return func(i int, addr netip.Addr) []string {
+		row := make([]string, len(columns))
+		for j, col := range columns {
+			switch col {
+			case "ip":
+				row[j] = addr.String()
+			case "cidr":
+				row[j] = sources[i]
+			case "prefix":
+				row[j] = prefixes[i]
+			case "offset":
+				row[j] = addrOffset(firsts[i], addr)
+			case "int":
+				row[j] = ipToInt(addr)
+			case "hex":
+				row[j] = ipToHex(addr)
+			case "mapped":
+				row[j] = ipToMapped(addr)
+			case "class":
+				row[j] = ipClass(addr)
+			}
+		}
+		return row
+	}
+}
+
+// prefixLength returns the prefix length of the CIDR source and the bit length
+// of its addresses, as in 20/32 for a /20, which leaves 12 host bits. A bare
+// IP is a full-length prefix, and a range, having no single prefix, gets an
+// empty field.
+func prefixLength(source string) string {
+	if strings.Contains(source, "-") || strings.Contains(source, "+") {
+		return ""
+	}
+	if !strings.Contains(source, "/") {
+		addr, err := netip.ParseAddr(source)
+		if err != nil {
+			return ""
+		}
+		addr = addr.Unmap()
+		return fmt.Sprintf("%d/%d", addr.BitLen(), addr.BitLen())
+	}
+
+	ones, bits := prefixBits(source)
+	if bits == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", ones, bits)
+}
+
+// addrOffset returns how many addresses addr is past first, of the same
+// family, as an unsigned decimal integer: 0 for first itself.
+func addrOffset(first, addr netip.Addr) string {
+	a, b := first.As16(), addr.As16()
+	lo, borrow := bits.Sub64(binary.BigEndian.Uint64(b[8:]), binary.BigEndian.Uint64(a[8:]), 0)
+	hi, _ := bits.Sub64(binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(a[:8]), borrow)
+	if hi == 0 {
+		return strconv.FormatUint(lo, 10)
+	}
+
+	offset := new(big.Int).SetUint64(hi)
+	return offset.Lsh(offset, 64).Or(offset, new(big.Int).SetUint64(lo)).String()
+}
+
+// ipToInt returns addr as an unsigned decimal integer: 32 bits wide for IPv4
+// and 128 bits wide for IPv6.
+func ipToInt(addr netip.Addr) string {
+	if addr.Is4() {
+		v4 := addr.As4()
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(v4[:])), 10)
+	}
+
+	v6 := addr.As16()
+	return new(big.Int).SetBytes(v6[:]).String()
+}
+
+// ipToHex returns the bytes of addr as uppercase hex digits, always 8 for
+// IPv4 and 32 for IPv6, leading zeros included, such as 0A000001 for 10.0.0.1.
+func ipToHex(addr netip.Addr) string {
+	return strings.ToUpper(hex.EncodeToString(addr.AsSlice()))
+}
+
+// ipToMapped returns addr in IPv6 form, as dual-stack systems take it: an
+// IPv4 address becomes IPv4-mapped, such as ::ffff:10.0.0.1 for 10.0.0.1,
+// while an IPv6 address is already one and stays as it is.
+func ipToMapped(addr netip.Addr) string {
+	if addr.Is4() {
+		return netip.AddrFrom16(addr.As16()).String()
+	}
+
+	return addr.String()
+}
+
+var (
+	// documentationPrefixes are set aside for examples: TEST-NET-1 to 3
+	// (RFC 5737) and the IPv6 documentation prefixes (RFC 3849, RFC 9637).
+	documentationPrefixes = []netip.Prefix{
+		netip.MustParsePrefix("192.0.2.0/24"),
+		netip.MustParsePrefix("198.51.100.0/24"),
+		netip.MustParsePrefix("203.0.113.0/24"),
+		netip.MustParsePrefix("2001:db8::/32"),
+		netip.MustParsePrefix("3fff::/20"),
+	}
+
+	// reservedPrefixes are never assigned: "this network" (RFC 791) and the
+	// former class E space up to the limited broadcast address (RFC 1112).
+	reservedPrefixes = []netip.Prefix{
+		netip.MustParsePrefix("0.0.0.0/8"),
+		netip.MustParsePrefix("240.0.0.0/4"),
+	}
+)
+
+// ipClass returns the kind of address addr is: unspecified, loopback,
+// link-local, multicast, private, documentation, reserved or, failing all of
+// those, global.
+func ipClass(addr netip.Addr) string {
+	switch {
+	case addr.IsUnspecified():
+		return "unspecified"
+	case addr.IsLoopback():
+		return "loopback"
+	case addr.IsLinkLocalUnicast():
+		return "link-local"
+	case addr.IsMulticast():
+		return "multicast"
+	case addr.IsPrivate():
+		return "private"
+	case inPrefixes(addr, documentationPrefixes):
+		return "documentation"
+	case inPrefixes(addr, reservedPrefixes):
+		return "reserved"
+	}
+
+	return "global"
+}
+
+func inPrefixes(addr netip.Addr, prefixes []netip.Prefix) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
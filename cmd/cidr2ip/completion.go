@@ -0,0 +1,140 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Flags whose values get special completion; every other flag taking a value
+// completes nothing in particular.
+var (
+	fileFlags = []string{"batch", "exclude-file", "f", "json-summary", "o"}
+	dirFlags  = []string{"outdir"}
+)
+
+// completionFlag is a flag as the completion scripts describe it.
+type completionFlag struct {
+	name   string
+	usage  string
+	isBool bool
+}
+
+// completionFlags returns the flags defined on fs, in lexical order, with the
+// first line of their usage text.
+func completionFlags(fs *flag.FlagSet) []completionFlag {
+	var flags []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		_, usage := flag.UnquoteUsage(f)
+		usage, _, _ = strings.Cut(usage, "\n")
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{name: f.Name, usage: usage, isBool: ok && b.IsBoolFlag()})
+	})
+
+	return flags
+}
+
+// printCompletion writes the completion script of shell, one of bash, zsh or
+// fish, for the flags defined on fs to w.
+func printCompletion(shell string, fs *flag.FlagSet, w io.Writer) error {
+	flags := completionFlags(fs)
+	names := strings.Split(formatNames(), ", ")
+
+	buf := bufio.NewWriter(w)
+	switch shell {
+	case "bash":
+		writeBashCompletion(buf, flags, names)
+	case "zsh":
+		writeZshCompletion(buf, flags, names)
+	case "fish":
+		writeFishCompletion(buf, flags, names)
+	default:
+		return withCode(exitUsage, fmt.Errorf("unsupported shell %q: use bash, zsh or fish", shell))
+	}
+
+	return buf.Flush()
+}
+
+func writeBashCompletion(w io.Writer, flags []completionFlag, formats []string) {
+	names := make([]string, len(flags))
+	for i, f := range flags {
+		names[i] = "-" + f.name
+	}
+
+	fmt.Fprintf(w, `# bash completion for %[1]s
+_%[1]s() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+    case "$prev" in
+        %[2]s)
+            COMPREPLY=($(compgen -f -- "$cur"))
+            return
+            ;;
+        %[3]s)
+            COMPREPLY=($(compgen -d -- "$cur"))
+            return
+            ;;
+        -format)
+            COMPREPLY=($(compgen -W "%[4]s" -- "$cur"))
+            return
+            ;;
+    esac
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "%[5]s" -- "$cur"))
+    fi
+}
+complete -F _%[1]s %[1]s
+`, app, dashed(fileFlags, "|"), dashed(dirFlags, "|"), strings.Join(formats, " "), strings.Join(names, " "))
+}
+
+func writeZshCompletion(w io.Writer, flags []completionFlag, formats []string) {
+	fmt.Fprintf(w, "#compdef %s\n\n_arguments \\\n", app)
+	for _, f := range flags {
+		desc := strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`).Replace(f.usage)
+		spec := fmt.Sprintf("-%s[%s]", f.name, desc)
+		switch {
+		case f.isBool:
+		case slices.Contains(fileFlags, f.name):
+			spec += ":file:_files"
+		case slices.Contains(dirFlags, f.name):
+			spec += ":directory:_files -/"
+		case f.name == "format":
+			spec += ":format:(" + strings.Join(formats, " ") + ")"
+		default:
+			spec += ":value: "
+		}
+		fmt.Fprintf(w, "  '%s' \\\n", spec)
+	}
+	fmt.Fprintln(w, "  '*:CIDR: '")
+}
+
+func writeFishCompletion(w io.Writer, flags []completionFlag, formats []string) {
+	fmt.Fprintf(w, "# fish completion for %[1]s\ncomplete -c %[1]s -f\n", app)
+	for _, f := range flags {
+		opt := "-o"
+		if len(f.name) == 1 {
+			opt = "-s"
+		}
+		line := fmt.Sprintf("complete -c %s %s %s -d '%s'", app, opt, f.name, strings.ReplaceAll(f.usage, "'", `\'`))
+		switch {
+		case f.isBool:
+		case slices.Contains(fileFlags, f.name), slices.Contains(dirFlags, f.name):
+			line += " -r -F"
+		case f.name == "format":
+			line += " -x -a '" + strings.Join(formats, " ") + "'"
+		default:
+			line += " -x"
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// dashed joins names with sep, each preceded by a dash.
+func dashed(names []string, sep string) string {
+	return "-" + strings.Join(names, sep+"-")
+}
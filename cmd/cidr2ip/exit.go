@@ -0,0 +1,59 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// Exit codes, so scripts can tell the kinds of failure apart.
+const (
+	exitFailure = 1 // any error not covered below
+	exitUsage   = 2 // no input, or invalid flags
+	exitParse   = 3 // invalid CIDRs or ranges
+	exitIO      = 4 // reading the input or writing the output failed
+
+	// exitInterrupted follows the shell convention of 128 plus SIGINT
+	exitInterrupted = 130
+)
+
+// codedError is an error that ends the program with a specific exit code.
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// withCode attaches an exit code to err, which may be nil.
+func withCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &codedError{code: code, err: err}
+}
+
+// exitCode returns the exit code err should end the program with. File
+// system errors are I/O errors even when they carry no code of their own.
+func exitCode(err error) int {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return exitIO
+	}
+
+	return exitFailure
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/rcmelendez/cidr2ip"
+)
+
+// addrRange is an inclusive range of addresses of a single family.
+type addrRange struct {
+	first, last netip.Addr
+}
+
+func (r addrRange) contains(addr netip.Addr) bool {
+	return addr.Compare(r.first) >= 0 && addr.Compare(r.last) <= 0
+}
+
+// parseAddrRange parses a CIDR or range like cidr2ip.ParseRange does.
+func parseAddrRange(s string) (addrRange, error) {
+	first, last, err := cidr2ip.ParseRange(s)
+	if err != nil {
+		return addrRange{}, err
+	}
+
+	start, _ := netip.AddrFromSlice(first)
+	end, _ := netip.AddrFromSlice(last)
+	return addrRange{start, end}, nil
+}
+
+// parseExclusions parses each -exclude entry, which is a single IP, a range
+// or a CIDR, into the range of addresses it covers.
+func parseExclusions(entries []string) ([]addrRange, error) {
+	var ranges []addrRange
+	for _, entry := range entries {
+		r, err := parseAddrRange(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -exclude %q: %w", entry, err)
+		}
+		ranges = append(ranges, r)
+	}
+
+	return ranges, nil
+}
+
+// readExclusions returns the ranges of the entries of every -exclude-file
+// file, which are read like the CIDR lists, comments, blank lines and URLs
+// fetched within timeout included.
+func readExclusions(files []string, timeout time.Duration) ([]addrRange, error) {
+	var ranges []addrRange
+	for _, file := range files {
+		inputs, err := readSource(file, timeout)
+		if err != nil {
+			return nil, err
+		}
+		for _, in := range inputs {
+			r, err := parseAddrRange(in.cidr)
+			if err != nil {
+				return nil, withCode(exitParse, fmt.Errorf("%s: invalid -exclude-file entry %q: %w", in.position(), in.cidr, err))
+			}
+			ranges = append(ranges, r)
+		}
+	}
+
+	return ranges, nil
+}
+
+// mergeRanges sorts ranges and joins those that overlap or touch, so an
+// address can be looked up among them with a binary search however long the
+// blocklist is. IPv4 ranges sort before IPv6 ones and are never joined to them.
+func mergeRanges(ranges []addrRange) []addrRange {
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b addrRange) int {
+		return a.first.Compare(b.first)
+	})
+
+	var merged []addrRange
+	for _, r := range sorted {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			next := last.last.Next()
+			if last.last.Is4() == r.first.Is4() && (!next.IsValid() || r.first.Compare(next) <= 0) {
+				if r.last.Compare(last.last) > 0 {
+					last.last = r.last
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+// isExcluded reports whether addr is in one of ranges, as merged by mergeRanges.
+func isExcluded(addr netip.Addr, ranges []addrRange) bool {
+	i := sort.Search(len(ranges), func(i int) bool {
+		return ranges[i].last.Compare(addr) >= 0
+	})
+
+	return i < len(ranges) && ranges[i].contains(addr)
+}
+
+// filterFamily returns the entries of inputs of address family 4 or 6, as
+// given by family, and how many others it left out. The family is told by the
+// length of the parsed first address, IPv4 always coming back in 4 bytes.
+// Invalid entries are kept for checkCIDRs to report.
+func filterFamily(inputs []cidrInput, family int) ([]cidrInput, int) {
+	var kept []cidrInput
+	for _, in := range inputs {
+		first, _, err := cidr2ip.ParseRange(in.cidr)
+		if err == nil && (len(first) == net.IPv4len) != (family == 4) {
+			continue
+		}
+		kept = append(kept, in)
+	}
+
+	return kept, len(inputs) - len(kept)
+}
+
+// filtersIPs reports whether opts selects any of the filters keepIP applies,
+// which leave out a share of the IPs that can't be known up front.
+func filtersIPs(opts *options) bool {
+	return len(opts.excluded) > 0 || opts.matchRE != nil || opts.privateOnly || opts.publicOnly
+}
+
+// keepIP reports whether addr passes the output filters selected in opts.
+func keepIP(addr netip.Addr, opts *options) bool {
+	if isExcluded(addr, opts.excluded) {
+		return false
+	}
+
+	if opts.matchRE != nil && !opts.matchRE.MatchString(addr.String()) {
+		return false
+	}
+
+	switch {
+	case opts.privateOnly:
+		return isPrivateIP(addr)
+	case opts.publicOnly:
+		return !isPrivateIP(addr)
+	}
+
+	return true
+}
+
+// isPrivateIP reports whether addr is not globally reachable: RFC 1918 and
+// unique local (fc00::/7) addresses, loopback, and link-local unicast.
+func isPrivateIP(addr netip.Addr) bool {
+	return addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast()
+}
@@ -0,0 +1,276 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/big"
+	"math/bits"
+	"net/netip"
+	"sync"
+
+	"github.com/rcmelendez/cidr2ip"
+)
+
+// generateBatch is the number of IPs a worker hands over at a time.
+const generateBatch = 1024
+
+// errStopped ends a worker's expansion once its IPs are no longer wanted.
+var errStopped = errors.New("generation stopped")
+
+// eachFunc walks the selected addresses of a single CIDR, like cidr2ip.EachAddr.
+type eachFunc func(cidr string, fn func(addr netip.Addr) error) error
+
+// eachBoundary is an eachFunc that walks only the first and last addresses
+// of a CIDR: its network and broadcast addresses. A single-address block has
+// just the one.
+func eachBoundary(cidr string, fn func(addr netip.Addr) error) error {
+	r, err := parseAddrRange(cidr)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(r.first); err != nil {
+		return err
+	}
+	if r.last == r.first {
+		return nil
+	}
+
+	return fn(r.last)
+}
+
+// eachNetwork is an eachFunc that walks only the network address of a CIDR,
+// its first address with the host bits cleared, as routes are written.
+func eachNetwork(cidr string, fn func(addr netip.Addr) error) error {
+	r, err := parseAddrRange(cidr)
+	if err != nil {
+		return err
+	}
+
+	return fn(r.first)
+}
+
+// eachUsableBoundary is an eachFunc like eachBoundary that walks the first and
+// last usable addresses of a CIDR instead, as usableRange finds them.
+func eachUsableBoundary(cidr string, fn func(addr netip.Addr) error) error {
+	_, first, last, _, err := usableRange(cidr)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(first); err != nil {
+		return err
+	}
+	if last == first {
+		return nil
+	}
+
+	return fn(last)
+}
+
+// eachTail returns an eachFunc that walks only the last n addresses of a CIDR,
+// or all of them if it has fewer. It seeks straight to the first of them, so
+// the addresses before are never generated.
+func eachTail(n int) eachFunc {
+	return func(cidr string, fn func(addr netip.Addr) error) error {
+		r, err := parseAddrRange(cidr)
+		if err != nil {
+			return err
+		}
+		size, err := cidr2ip.CountCIDR(cidr)
+		if err != nil {
+			return err
+		}
+
+		addr := r.first
+		if skip := size.Sub(size, big.NewInt(int64(n))); skip.Sign() > 0 {
+			addr = ipAtOffset(r.first.AsSlice(), skip)
+		}
+		for {
+			if err := fn(addr); err != nil {
+				return err
+			}
+			if addr == r.last {
+				return nil
+			}
+			addr = addr.Next()
+		}
+	}
+}
+
+// eachEvery returns an eachFunc that walks every kth address of a CIDR,
+// starting from its first one. The addresses in between are skipped over
+// rather than generated, so a large stride stays cheap on any block.
+func eachEvery(k int) eachFunc {
+	return func(cidr string, fn func(addr netip.Addr) error) error {
+		r, err := parseAddrRange(cidr)
+		if err != nil {
+			return err
+		}
+
+		addr := r.first
+		for {
+			if err := fn(addr); err != nil {
+				return err
+			}
+			next, ok := addrAdd(addr, uint64(k))
+			if !ok || next.Compare(r.last) > 0 {
+				return nil
+			}
+			addr = next
+		}
+	}
+}
+
+// addrAdd returns the address n positions after addr, and false if that is
+// past the end of the address family.
+func addrAdd(addr netip.Addr, n uint64) (netip.Addr, bool) {
+	if addr.Is4() {
+		v4 := addr.As4()
+		sum := uint64(binary.BigEndian.Uint32(v4[:])) + n
+		if sum > math.MaxUint32 {
+			return netip.Addr{}, false
+		}
+		binary.BigEndian.PutUint32(v4[:], uint32(sum))
+		return netip.AddrFrom4(v4), true
+	}
+
+	v6 := addr.As16()
+	lo, carry := bits.Add64(binary.BigEndian.Uint64(v6[8:]), n, 0)
+	hi, carry := bits.Add64(binary.BigEndian.Uint64(v6[:8]), 0, carry)
+	if carry != 0 {
+		return netip.Addr{}, false
+	}
+	binary.BigEndian.PutUint64(v6[:8], hi)
+	binary.BigEndian.PutUint64(v6[8:], lo)
+	return netip.AddrFrom16(v6), true
+}
+
+// ipBatch is a run of consecutive IPs of one CIDR, or the error that ended
+// its expansion.
+type ipBatch struct {
+	addrs []netip.Addr
+	err   error
+}
+
+// generateIPs passes the IPs that each selects from every CIDR to fn, in
+// input order, along with the index in cidrs of the CIDR they came from.
+// Up to jobs CIDRs are expanded concurrently, but fn is always called from a
+// single goroutine and sees exactly the same sequence as with jobs set to 1.
+func generateIPs(cidrs []string, each eachFunc, jobs int, fn func(i int, addr netip.Addr) error) error {
+	if jobs <= 1 || len(cidrs) <= 1 {
+		for i, cidr := range cidrs {
+			err := each(cidr, func(addr netip.Addr) error {
+				return fn(i, addr)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var (
+		// queue holds one channel per CIDR in input order; its capacity,
+		// together with sem, bounds how many CIDRs are in flight
+		queue = make(chan chan ipBatch, jobs)
+		sem   = make(chan struct{}, jobs)
+		done  = make(chan struct{})
+		wg    sync.WaitGroup
+	)
+
+	go func() {
+		defer close(queue)
+		for _, cidr := range cidrs {
+			select {
+			case sem <- struct{}{}:
+			case <-done:
+				return
+			}
+
+			out := make(chan ipBatch, 1)
+			queue <- out
+			wg.Add(1)
+			go func(cidr string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				expandCIDR(cidr, each, out, done)
+			}(cidr)
+		}
+	}()
+
+	err := consumeBatches(queue, fn)
+
+	// Stop the workers and wait for them, so none outlives the call
+	close(done)
+	for out := range queue {
+		for range out {
+		}
+	}
+	wg.Wait()
+
+	return err
+}
+
+// expandCIDR sends the IPs of cidr to out in batches and closes it. It gives
+// up as soon as done is closed.
+func expandCIDR(cidr string, each eachFunc, out chan<- ipBatch, done <-chan struct{}) {
+	defer close(out)
+
+	send := func(b ipBatch) bool {
+		select {
+		case out <- b:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	var addrs []netip.Addr
+	err := each(cidr, func(addr netip.Addr) error {
+		addrs = append(addrs, addr)
+		if len(addrs) < generateBatch {
+			return nil
+		}
+		if !send(ipBatch{addrs: addrs}) {
+			return errStopped
+		}
+		addrs = nil
+		return nil
+	})
+	if errors.Is(err, errStopped) {
+		return
+	}
+
+	if len(addrs) > 0 && !send(ipBatch{addrs: addrs}) {
+		return
+	}
+	if err != nil {
+		send(ipBatch{err: err})
+	}
+}
+
+// consumeBatches calls fn for every IP received through queue, in order, and
+// returns the first error met.
+func consumeBatches(queue <-chan chan ipBatch, fn func(i int, addr netip.Addr) error) error {
+	i := 0
+	for out := range queue {
+		for b := range out {
+			if b.err != nil {
+				return b.err
+			}
+			for _, addr := range b.addrs {
+				if err := fn(i, addr); err != nil {
+					return err
+				}
+			}
+		}
+		i++
+	}
+
+	return nil
+}
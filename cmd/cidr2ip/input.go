@@ -0,0 +1,364 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rcmelendez/cidr2ip"
+)
+
+// cidrInput is a CIDR as read from the input, along with where it came from
+// so errors can point at the offending entry.
+type cidrInput struct {
+	cidr   string
+	source string // file name, "stdin", or empty for command-line arguments
+	line   int    // line number in source, or argument position
+}
+
+func (c cidrInput) position() string {
+	if c.source == "" {
+		return fmt.Sprintf("argument %d", c.line)
+	}
+
+	return fmt.Sprintf("%s:%d", c.source, c.line)
+}
+
+func cidrStrings(inputs []cidrInput) []string {
+	cidrs := make([]string, len(inputs))
+	for i, in := range inputs {
+		cidrs[i] = in.cidr
+	}
+
+	return cidrs
+}
+
+// locate rewrites err, as returned by a cidr2ip function given the CIDRs of
+// inputs, to point at where the offending entry was read from instead of its
+// position in the list, which lines skipped as comments or several sources
+// make meaningless to the user.
+func locate(err error, inputs []cidrInput) error {
+	var pe *cidr2ip.ParseError
+	if !errors.As(err, &pe) || pe.Line < 1 || pe.Line > len(inputs) {
+		return err
+	}
+
+	return fmt.Errorf("%s: %w", inputs[pe.Line-1].position(), pe.Err)
+}
+
+// checkCIDRs splits inputs into the valid CIDRs and an error for each invalid
+// one, both in input order. With strict set, CIDRs with host bits set, such as
+// 10.0.0.5/24, are invalid too instead of standing for their network.
+func checkCIDRs(inputs []cidrInput, strict bool) ([]string, []error) {
+	var (
+		valid   []string
+		invalid []error
+	)
+
+	for _, in := range inputs {
+		_, _, err := cidr2ip.ParseRange(in.cidr)
+		if err == nil && strict {
+			err = checkHostBits(in.cidr)
+		}
+		if err != nil {
+			invalid = append(invalid, withCode(exitParse, fmt.Errorf("%s: %w", in.position(), err)))
+			continue
+		}
+		valid = append(valid, in.cidr)
+	}
+
+	return valid, invalid
+}
+
+// checkHostBits fails if cidr is a CIDR notation whose address has bits set
+// past the prefix length, as netip.ParsePrefix would accept but Masked
+// changes. Ranges and bare addresses have no host bits and always pass.
+func checkHostBits(cidr string) error {
+	if !strings.Contains(cidr, "/") || strings.Contains(cidr, "-") {
+		return nil
+	}
+
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return err
+	}
+	if masked := prefix.Masked(); masked != prefix {
+		return fmt.Errorf("%s has host bits set (did you mean %s?)", cidr, masked)
+	}
+
+	return nil
+}
+
+// findDupCIDRs describes every entry of inputs covering exactly the same
+// addresses as an earlier one, which usually means a copy-paste mistake.
+// Entries are compared by what they parse to, so 10.0.0.0/24 repeats itself
+// whatever spacing either copy has, and so does 10.0.0.0-10.0.0.255. Invalid
+// entries are left to checkCIDRs.
+func findDupCIDRs(inputs []cidrInput) []string {
+	var dups []string
+	seen := make(map[addrRange]cidrInput)
+	for _, in := range inputs {
+		r, err := parseAddrRange(strings.TrimSpace(in.cidr))
+		if err != nil {
+			continue
+		}
+		if first, ok := seen[r]; ok {
+			dups = append(dups, fmt.Sprintf("%s: %s repeats %s from %s", in.position(), in.cidr, first.cidr, first.position()))
+			continue
+		}
+		seen[r] = in
+	}
+
+	return dups
+}
+
+// readCIDRs returns the CIDRs of every file in files, in order, followed by
+// the command-line arguments. Stdin is only read when there are neither. A
+// file given as an http:// or https:// URL is fetched within timeout.
+func readCIDRs(files []string, timeout time.Duration) ([]cidrInput, error) {
+	var inputs []cidrInput
+	for _, file := range files {
+		read, err := readSource(file, timeout)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, read...)
+	}
+
+	for i, arg := range flag.Args() {
+		inputs = append(inputs, cidrInput{cidr: arg, line: i + 1})
+	}
+
+	if len(inputs) > 0 {
+		return inputs, nil
+	}
+
+	return readFromStdin()
+}
+
+// stdinIsPipe reports whether stdin is redirected from a pipe or file rather
+// than attached to an interactive terminal.
+func stdinIsPipe() bool {
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+
+	return stat.Mode()&os.ModeCharDevice == 0
+}
+
+func readFromStdin() ([]cidrInput, error) {
+	inputs, err := scanCIDRs(os.Stdin, "stdin")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(inputs) == 0 {
+		return nil, withCode(exitUsage, fmt.Errorf("no CIDRs read from stdin"))
+	}
+
+	return inputs, nil
+}
+
+// scanCIDRs reads one CIDR per line from r. Blank lines and comments starting
+// with # are skipped, including trailing comments after a CIDR.
+func scanCIDRs(r io.Reader, source string) ([]cidrInput, error) {
+	var inputs []cidrInput
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		inputs = append(inputs, cidrInput{cidr: line, source: source, line: n})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return inputs, nil
+}
+
+// isURL reports whether source names an HTTP resource rather than a file.
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// maxIncludeDepth is how deeply @ includes may nest, the top file being 0.
+const maxIncludeDepth = 16
+
+// readSource returns the CIDRs of source, a file name or a URL to fetch within
+// timeout. A line starting with @ includes the CIDRs of another file or URL in
+// its place, read the same way, so a manifest can list the files to read.
+func readSource(source string, timeout time.Duration) ([]cidrInput, error) {
+	return readIncluding(source, timeout, make(map[string]bool))
+}
+
+// readIncluding reads source like readSource. reading holds the sources whose
+// includes are being read, which source must not be one of, as it would end
+// up including itself.
+func readIncluding(source string, timeout time.Duration, reading map[string]bool) ([]cidrInput, error) {
+	key := source
+	if !isURL(source) {
+		if abs, err := filepath.Abs(source); err == nil {
+			key = abs
+		}
+	}
+	if reading[key] {
+		return nil, withCode(exitUsage, fmt.Errorf("include cycle: %s includes itself", source))
+	}
+	if len(reading) > maxIncludeDepth {
+		return nil, withCode(exitUsage, fmt.Errorf("%s: includes nested more than %d deep", source, maxIncludeDepth))
+	}
+
+	var (
+		read []cidrInput
+		err  error
+	)
+	if isURL(source) {
+		read, err = readFromURL(source, timeout)
+	} else {
+		read, err = readFromFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reading[key] = true
+	defer delete(reading, key)
+
+	inputs := make([]cidrInput, 0, len(read))
+	for _, in := range read {
+		target, ok := strings.CutPrefix(in.cidr, "@")
+		if !ok {
+			inputs = append(inputs, in)
+			continue
+		}
+
+		target, err := resolveInclude(source, strings.TrimSpace(target))
+		if err != nil {
+			return nil, withCode(exitUsage, fmt.Errorf("%s: %w", in.position(), err))
+		}
+		included, err := readIncluding(target, timeout, reading)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", in.position(), err)
+		}
+		inputs = append(inputs, included...)
+	}
+
+	return inputs, nil
+}
+
+// resolveInclude returns the source an @ line of source names. Relative names
+// are relative to where source is: its directory, or its URL.
+func resolveInclude(source, target string) (string, error) {
+	if target == "" {
+		return "", errors.New("@ names no file to include")
+	}
+	if isURL(target) {
+		return target, nil
+	}
+
+	if isURL(source) {
+		base, err := url.Parse(source)
+		if err != nil {
+			return "", err
+		}
+		ref, err := url.Parse(target)
+		if err != nil {
+			return "", err
+		}
+		return base.ResolveReference(ref).String(), nil
+	}
+
+	if filepath.IsAbs(target) {
+		return target, nil
+	}
+	return filepath.Join(filepath.Dir(source), target), nil
+}
+
+// readFromURL fetches url and returns the CIDRs of its body, which is read
+// like a file. The whole request, body included, must complete within
+// timeout, and any status other than 200 OK is an error.
+func readFromURL(url string, timeout time.Duration) ([]cidrInput, error) {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, withCode(exitIO, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, withCode(exitIO, fmt.Errorf("%s: unexpected status %s", url, resp.Status))
+	}
+
+	return readList(resp.Body, url)
+}
+
+// gzipMagic starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// readFromFile returns the CIDRs of file, decompressing it first if it is
+// gzip-compressed.
+func readFromFile(file string) ([]cidrInput, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if stat.Size() == 0 {
+		return nil, withCode(exitUsage, fmt.Errorf("empty file: %s", file))
+	}
+
+	return readList(f, file)
+}
+
+// readList returns the CIDRs read from r, decompressing them first if they are
+// gzip-compressed. The CIDRs are reported as coming from file.
+func readList(r io.Reader, file string) ([]cidrInput, error) {
+	// Compressed lists are recognized by their magic number, whatever their name
+	br := bufio.NewReader(r)
+	r = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, withCode(exitIO, fmt.Errorf("%s: %w", file, err))
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	inputs, err := scanCIDRs(r, file)
+	if err != nil {
+		return nil, withCode(exitIO, fmt.Errorf("%s: %w", file, err))
+	}
+
+	if len(inputs) == 0 {
+		return nil, withCode(exitUsage, fmt.Errorf("no CIDRs found in file: %s", file))
+	}
+
+	return inputs, nil
+}
@@ -0,0 +1,1025 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/rcmelendez/cidr2ip"
+)
+
+const (
+	app     = "cidr2ip"
+	version = "1.0.0"
+
+	// defaultMaxIPs leaves room for a full /8 plus change, while stopping
+	// mistakes like a /0 or an IPv6 block that would take forever to expand.
+	defaultMaxIPs = 1 << 25
+)
+
+// options holds the parsed command-line flags.
+type options struct {
+	files          stringList
+	format         string
+	output         string
+	force          bool
+	count          bool
+	keepGoing      bool
+	resolve        bool
+	resolveTimeout time.Duration
+	limit          int
+	sample         int
+	seed           int64
+	dedup          bool
+	merge          bool
+	intColumn      bool
+	quiet          bool
+	split          string
+	maxIPs         uint64
+	privateOnly    bool
+	publicOnly     bool
+	delimiter      string
+	header         bool
+	withCIDR       bool
+	exclude        stringList
+	excludeFiles   stringList
+	check          bool
+	jobs           int
+	appendOutput   bool
+	stats          bool
+	sort           bool
+	hostnamePrefix string
+	table          string
+	sqlBatch       int
+	boundaries     bool
+	usableHosts    bool
+	onlyNetwork    bool
+	wildcard       bool
+	contains       string
+	warnOverlap    bool
+	match          string
+	dryRun         bool
+	splitFiles     bool
+	outdir         string
+	columns        string
+	collapse       bool
+	uniqueCount    bool
+	tail           int
+	every          int
+	strict         bool
+	verbose        bool
+	countPerCIDR   bool
+	noDupCIDRs     bool
+	warn           bool
+	family         int
+	shuffle        bool
+	timeout        time.Duration
+	jsonSummary    string
+	prefixSummary  bool
+	maskFormat     string
+	template       string
+	batch          string
+
+	// comma is the CSV field separator parsed from delimiter.
+	comma rune
+	// excluded holds the parsed -exclude and -exclude-file entries, merged.
+	excluded []addrRange
+	// matchRE is the compiled -match pattern, or nil.
+	matchRE *regexp.Regexp
+	// columnList holds the output columns, from -columns or the flags adding them.
+	columnList []string
+	// logger logs each CIDR expanded for -V, or is nil.
+	logger *cidrLogger
+	// tmpl is the compiled -template, or nil.
+	tmpl *template.Template
+	// total is the number of IPs the run is expected to write, as main
+	// computed it for the progress indicator, or nil.
+	total *big.Int
+	// noProgress hides the progress indicator alone, unlike quiet.
+	noProgress bool
+}
+
+// envDefault returns the value of the environment variable key, or def if it
+// is unset or empty. Only a few flags take their default from the environment,
+// and a flag given on the command line always wins.
+func envDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+
+	return def
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func main() {
+	start := time.Now()
+
+	var (
+		opts        options
+		helpFlag    bool
+		versionFlag bool
+	)
+
+	flag.Var(&opts.files, "f", "Read CIDRs from `filename` or an http(s) URL (repeatable, read in order)")
+	flag.StringVar(&opts.batch, "batch", "", "Run the jobs of the JSON `config` file, each with its own CIDRs, output and format")
+	flag.DurationVar(&opts.timeout, "timeout", 30*time.Second, "Maximum `duration` of fetching each -f or -exclude-file URL")
+	flag.StringVar(&opts.format, "format", envDefault("CIDR2IP_FORMAT", "csv"), "Output `format`: "+formatNames())
+	flag.StringVar(&opts.template, "template", "", "Write each IP as the Go `template` executed with its fields, such as {{.IP}} and {{.CIDR}}")
+	flag.StringVar(&opts.delimiter, "delimiter", ",", "CSV field `separator`, a single character (use \\t for tab)")
+	flag.StringVar(&opts.hostnamePrefix, "hostname-prefix", "host-", "Host name `prefix` for the hosts format")
+	flag.StringVar(&opts.table, "table", "ips", "Table `name` of the sql format's INSERT statements")
+	flag.IntVar(&opts.sqlBatch, "sql-batch", 1000, "Maximum `rows` per INSERT statement of the sql format")
+	flag.BoolVar(&opts.header, "header", false, "Start the CSV output with a row of column names")
+	flag.StringVar(&opts.output, "o", "", "Write the IP list to `filename` (use - for stdout)")
+	flag.BoolVar(&opts.splitFiles, "split-files", false, "Write the IPs of each CIDR to its own file, named after the block")
+	flag.StringVar(&opts.outdir, "outdir", envDefault("CIDR2IP_OUTDIR", "."), "`Directory` for the timestamped output file and the -split-files files")
+	flag.BoolVar(&opts.force, "force", false, "Overwrite the -o file if it already exists")
+	flag.BoolVar(&opts.appendOutput, "append", false, "Add the IPs to the end of the -o file instead of replacing it")
+	flag.BoolVar(&opts.count, "count", false, "Print the number of IPs per CIDR instead of saving them")
+	flag.BoolVar(&opts.countPerCIDR, "count-per-cidr", false, "Write a CSV table of the IPs and usable hosts of each CIDR, to -o or stdout")
+	flag.BoolVar(&opts.prefixSummary, "prefix-summary", false, "Write a CSV table of the network, usable range, broadcast and hosts of each CIDR, to -o or stdout")
+	flag.StringVar(&opts.maskFormat, "mask-format", "", "Add a mask column to -count-per-cidr and -prefix-summary, in `format` dotted or prefix")
+	flag.BoolVar(&opts.uniqueCount, "unique-count", false, "Print the number of distinct IPs covered by all CIDRs, counting overlaps once")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, "Print the file name and number of IPs a run would write, without writing anything")
+	flag.BoolVar(&opts.check, "check", false, "Only validate the CIDRs and report every invalid one, without writing anything")
+	flag.BoolVar(&opts.wildcard, "wildcard", false, "Print the network address and wildcard mask of each CIDR instead of its IPs")
+	flag.StringVar(&opts.contains, "contains", "", "Print the input CIDRs containing `ip` instead of expanding them")
+	flag.BoolVar(&opts.collapse, "collapse", false, "Read a list of IPs and print the smallest set of CIDRs covering them")
+	flag.StringVar(&opts.split, "split", "", "Print the subnets of `/len` contained in each CIDR instead of its IPs")
+	flag.Uint64Var(&opts.maxIPs, "max-ips", defaultMaxIPs, "Refuse to write more than `N` IPs in total (0 means no limit)")
+	flag.IntVar(&opts.family, "family", 0, "Only use the input CIDRs of address family `4` or 6, skipping the others")
+	flag.BoolVar(&opts.privateOnly, "private-only", false, "Only write private, loopback and link-local IPs")
+	flag.BoolVar(&opts.publicOnly, "public-only", false, "Only write public IPs")
+	flag.StringVar(&opts.match, "match", "", "Only write the IPs whose text matches the `regexp`")
+	flag.Var(&opts.exclude, "exclude", "Leave out an `IP`, range or CIDR from the output (repeatable)")
+	flag.Var(&opts.excludeFiles, "exclude-file", "Leave out the IPs, ranges and CIDRs listed in `file`, one per line (repeatable)")
+	flag.IntVar(&opts.jobs, "j", runtime.NumCPU(), "Expand up to `N` CIDRs concurrently; the output order is unaffected")
+	flag.BoolVar(&opts.strict, "strict", false, "Reject CIDRs with host bits set, such as 10.0.0.5/24, instead of using their network")
+	flag.BoolVar(&opts.keepGoing, "keep-going", false, "Expand the valid CIDRs and report all invalid ones at the end")
+	flag.BoolVar(&opts.resolve, "resolve", false, "Add a column with the reverse DNS (PTR) name of each IP.\nThis dramatically slows down large ranges")
+	flag.DurationVar(&opts.resolveTimeout, "resolve-timeout", 2*time.Second, "Maximum `duration` of each reverse DNS lookup")
+	flag.IntVar(&opts.limit, "limit", 0, "Stop after the first `N` IPs across all CIDRs (0 means no limit)")
+	flag.BoolVar(&opts.boundaries, "boundaries", false, "Write only the network and broadcast addresses of each CIDR")
+	flag.BoolVar(&opts.boundaries, "endpoints", false, "Same as -boundaries")
+	flag.BoolVar(&opts.onlyNetwork, "only-network", false, "Write only the network address of each CIDR")
+	flag.BoolVar(&opts.usableHosts, "hosts", false, "With -boundaries, write the first and last usable addresses instead; with -count, count only usable hosts")
+	flag.IntVar(&opts.tail, "tail", 0, "Write only the last `N` IPs of each CIDR")
+	flag.IntVar(&opts.every, "every", 0, "Write only every `K`th IP of each CIDR, starting from the first")
+	flag.IntVar(&opts.sample, "sample", 0, "Pick `K` distinct random IPs from each CIDR instead of all of them")
+	flag.Int64Var(&opts.seed, "seed", 0, "Random `seed` for -sample and -shuffle, for reproducible results (0 picks one at random)")
+	flag.BoolVar(&opts.sort, "sort", false, "Write the IPs in ascending numeric order across all CIDRs.\nThe whole list is held in memory")
+	flag.BoolVar(&opts.shuffle, "shuffle", false, "Write the IPs in random order across all CIDRs.\nThe whole list is held in memory")
+	flag.BoolVar(&opts.noDupCIDRs, "no-dup-cidrs", false, "Fail if the same CIDR appears more than once in the input, listing the repeats")
+	flag.BoolVar(&opts.warn, "warn", false, "With -no-dup-cidrs, only warn about the repeats on stderr and carry on")
+	flag.BoolVar(&opts.warnOverlap, "warn-overlap", false, "Warn on stderr about every pair of input CIDRs sharing addresses")
+	flag.BoolVar(&opts.dedup, "dedup", false, "Write each IP only once, even if it is covered by several CIDRs")
+	flag.BoolVar(&opts.merge, "merge", false, "Aggregate the CIDRs into the smallest covering set before expanding them")
+	flag.BoolVar(&opts.intColumn, "int", false, "Add a column with each IP as an unsigned integer")
+	flag.BoolVar(&opts.withCIDR, "with-cidr", false, "Add a column with the CIDR each IP came from")
+	flag.StringVar(&opts.columns, "columns", "", "Comma-separated output `columns`, in order, from: "+strings.Join(columnNames, ", "))
+	flag.BoolVar(&opts.stats, "stats", false, "Print a summary of the run to stderr once the IPs are written")
+	flag.StringVar(&opts.jsonSummary, "json-summary", "", "Write a JSON report of the run to `file` (use - for stderr)")
+	flag.BoolVar(&opts.quiet, "q", false, "Don't show progress or the success message; errors are still shown")
+	flag.BoolVar(&opts.quiet, "quiet", false, "Same as -q")
+	flag.BoolVar(&opts.verbose, "V", false, "Log the IP count and expansion time of each CIDR to stderr, then the totals")
+	flag.BoolVar(&opts.verbose, "verbose", false, "Same as -V")
+	flag.BoolVar(&helpFlag, "h", false, "Show help menu")
+	flag.BoolVar(&versionFlag, "v", false, "Show version")
+
+	// Subcommands come before the flags, which they describe rather than use
+	if len(os.Args) > 1 && os.Args[1] == "completion" {
+		if len(os.Args) != 3 {
+			fmt.Fprintln(os.Stderr, "Error: Usage: cidr2ip completion bash|zsh|fish")
+			os.Exit(exitUsage)
+		}
+		handleError(printCompletion(os.Args[2], flag.CommandLine, os.Stdout))
+		return
+	}
+
+	flag.Parse()
+
+	if versionFlag {
+		printVersion()
+		os.Exit(0)
+	}
+
+	if helpFlag {
+		printHelp()
+		os.Exit(0)
+	}
+
+	if len(opts.files) == 0 && flag.NArg() == 0 && !stdinIsPipe() && opts.batch == "" {
+		fmt.Fprintln(os.Stderr, "Error: No CIDRs provided. Use -h for help.")
+		os.Exit(exitUsage)
+	}
+
+	// -template selects its format, which is useless without one
+	if opts.template != "" {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "format" && opts.format != "template" {
+				fmt.Fprintf(os.Stderr, "Error: -template can't be used with -format %s.\n", opts.format)
+				os.Exit(exitUsage)
+			}
+		})
+		opts.format = "template"
+	} else if opts.format == "template" {
+		fmt.Fprintln(os.Stderr, "Error: -format template requires -template.")
+		os.Exit(exitUsage)
+	}
+
+	if _, ok := formats[opts.format]; !ok {
+		fmt.Fprintf(os.Stderr, "Error: Invalid format %q. Use one of: %s.\n", opts.format, formatNames())
+		os.Exit(exitUsage)
+	}
+
+	comma, err := parseDelimiter(opts.delimiter)
+	handleError(withCode(exitUsage, err))
+	opts.comma = comma
+
+	handleError(withCode(exitUsage, checkTableName(opts.table)))
+
+	if opts.contains != "" {
+		if _, err := netip.ParseAddr(opts.contains); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Invalid -contains address %q.\n", opts.contains)
+			os.Exit(exitUsage)
+		}
+	}
+
+	if opts.sqlBatch < 1 {
+		fmt.Fprintln(os.Stderr, "Error: -sql-batch must be at least 1.")
+		os.Exit(exitUsage)
+	}
+
+	if opts.limit < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -limit must not be negative.")
+		os.Exit(exitUsage)
+	}
+
+	if opts.sample < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -sample must not be negative.")
+		os.Exit(exitUsage)
+	}
+
+	if opts.tail < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -tail must not be negative.")
+		os.Exit(exitUsage)
+	}
+
+	if opts.every < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -every must not be negative.")
+		os.Exit(exitUsage)
+	}
+
+	// Under -batch, each job's output and format are checked as it runs
+	if opts.appendOutput {
+		switch {
+		case opts.force:
+			fmt.Fprintln(os.Stderr, "Error: -append and -force can't be used together.")
+			os.Exit(exitUsage)
+		case opts.batch != "":
+		case opts.output == "" || isStdout(opts.output):
+			fmt.Fprintln(os.Stderr, "Error: -append requires an -o file.")
+			os.Exit(exitUsage)
+		case opts.format == "json" || opts.format == "parquet":
+			fmt.Fprintf(os.Stderr, "Error: -append can't be used with the %s format.\n", opts.format)
+			os.Exit(exitUsage)
+		}
+	}
+
+	if opts.splitFiles {
+		switch {
+		case opts.output != "":
+			fmt.Fprintln(os.Stderr, "Error: -split-files names its own files and can't be used with -o.")
+			os.Exit(exitUsage)
+		case opts.merge || opts.dedup:
+			fmt.Fprintln(os.Stderr, "Error: -split-files can't be used with -merge or -dedup.")
+			os.Exit(exitUsage)
+		}
+	}
+
+	if opts.jobs < 1 {
+		fmt.Fprintln(os.Stderr, "Error: -j must be at least 1.")
+		os.Exit(exitUsage)
+	}
+
+	selections := 0
+	for _, set := range []bool{opts.boundaries, opts.onlyNetwork, opts.sample > 0, opts.tail > 0, opts.every > 0} {
+		if set {
+			selections++
+		}
+	}
+	if selections > 1 {
+		fmt.Fprintln(os.Stderr, "Error: Only one of -boundaries, -only-network, -sample, -tail and -every can be used at a time.")
+		os.Exit(exitUsage)
+	}
+
+	switch opts.maskFormat {
+	case "", "dotted", "prefix":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: Invalid -mask-format %q. Use dotted or prefix.\n", opts.maskFormat)
+		os.Exit(exitUsage)
+	}
+
+	if opts.usableHosts && !opts.boundaries && !opts.count {
+		fmt.Fprintln(os.Stderr, "Error: -hosts requires -boundaries, -endpoints or -count.")
+		os.Exit(exitUsage)
+	}
+
+	if opts.sort && opts.shuffle {
+		fmt.Fprintln(os.Stderr, "Error: -sort and -shuffle can't be used together.")
+		os.Exit(exitUsage)
+	}
+
+	if opts.family != 0 && opts.family != 4 && opts.family != 6 {
+		fmt.Fprintln(os.Stderr, "Error: -family must be 4 or 6.")
+		os.Exit(exitUsage)
+	}
+
+	if opts.privateOnly && opts.publicOnly {
+		fmt.Fprintln(os.Stderr, "Error: -private-only and -public-only can't be used together.")
+		os.Exit(exitUsage)
+	}
+
+	excluded, err := parseExclusions(opts.exclude)
+	handleError(withCode(exitUsage, err))
+	fromFiles, err := readExclusions(opts.excludeFiles, opts.timeout)
+	handleError(err)
+	opts.excluded = mergeRanges(append(excluded, fromFiles...))
+
+	if opts.match != "" {
+		opts.matchRE, err = regexp.Compile(opts.match)
+		handleError(withCode(exitUsage, err))
+	}
+
+	// The template's fields pick its default columns
+	if opts.template != "" {
+		opts.tmpl, err = parseTemplate(opts.template)
+		handleError(withCode(exitUsage, err))
+	}
+
+	opts.columnList, err = parseColumns(&opts)
+	handleError(withCode(exitUsage, err))
+
+	if opts.batch != "" {
+		if flag.NArg() > 0 {
+			fmt.Fprintln(os.Stderr, "Error: -batch reads its CIDRs from the config and can't be used with arguments.")
+			os.Exit(exitUsage)
+		}
+		handleError(checkBatchFlags(flag.CommandLine))
+		handleError(runBatch(opts.batch, &opts))
+		return
+	}
+
+	inputs, err := readCIDRs(opts.files, opts.timeout)
+	handleError(err)
+
+	if opts.family != 0 {
+		var skipped int
+		inputs, skipped = filterFamily(inputs, opts.family)
+		if skipped > 0 && !opts.quiet {
+			warnf("skipped %d CIDR(s) not of IPv%d", skipped, opts.family)
+		}
+		if len(inputs) == 0 {
+			handleError(withCode(exitUsage, fmt.Errorf("no IPv%d CIDRs in the input", opts.family)))
+		}
+	}
+
+	if opts.count {
+		handleError(printCounts(cidrStrings(inputs), opts.usableHosts, os.Stdout))
+		return
+	}
+
+	if opts.countPerCIDR {
+		handleError(writeCountPerCIDR(cidrStrings(inputs), &opts))
+		return
+	}
+
+	if opts.prefixSummary {
+		handleError(writePrefixSummary(cidrStrings(inputs), &opts))
+		return
+	}
+
+	if opts.uniqueCount {
+		handleError(printUniqueCount(inputs, os.Stdout))
+		return
+	}
+
+	if opts.split != "" {
+		handleError(printSplit(inputs, opts.split, os.Stdout))
+		return
+	}
+
+	if opts.wildcard {
+		handleError(printWildcards(inputs, os.Stdout))
+		return
+	}
+
+	if opts.contains != "" {
+		handleError(printContaining(inputs, opts.contains, os.Stdout))
+		return
+	}
+
+	if opts.collapse {
+		handleError(printCollapsed(inputs, opts.comma, os.Stdout))
+		return
+	}
+
+	// Catch invalid CIDRs before any output file is created
+	cidrs, invalid := checkCIDRs(inputs, opts.strict)
+	if opts.noDupCIDRs {
+		if dups := findDupCIDRs(inputs); len(dups) > 0 {
+			if !opts.warn {
+				fmt.Fprintf(os.Stderr, "Error: %d duplicate CIDR(s):\n", len(dups))
+				for _, dup := range dups {
+					fmt.Fprintf(os.Stderr, "  %s\n", dup)
+				}
+				os.Exit(exitParse)
+			}
+			for _, dup := range dups {
+				warnf("duplicate CIDR: %s", dup)
+			}
+		}
+	}
+	if opts.check {
+		if len(invalid) > 0 {
+			printInvalid(invalid)
+			os.Exit(exitParse)
+		}
+		if !opts.quiet {
+			fmt.Printf("%d CIDR(s) OK\n", len(cidrs))
+		}
+		return
+	}
+
+	var st *runStats
+	if opts.stats || opts.jsonSummary != "" {
+		st = &runStats{start: start, cidrs: len(cidrs)}
+	}
+
+	if len(invalid) > 0 && !opts.keepGoing {
+		if opts.jsonSummary != "" {
+			handleError(writeSummary(opts.jsonSummary, len(inputs), st, nil, invalid[:1], nil))
+		}
+		handleError(invalid[0])
+	}
+
+	if opts.warnOverlap {
+		overlaps, err := cidr2ip.FindOverlaps(cidrs)
+		handleError(err)
+		for _, pair := range overlaps {
+			warnf("%s overlaps %s", pair[0], pair[1])
+		}
+	}
+
+	// An explicit -o wins over -outdir, which only places the timestamped file
+	file := opts.output
+	if file == "" {
+		file = filepath.Join(opts.outdir, fmt.Sprintf("%s_%s.%s", app, time.Now().Format("2006-01-02_15-04-05"), opts.format))
+	} else if !isStdout(file) {
+		file, err = filepath.Abs(file)
+		handleError(err)
+	}
+
+	// Computed once up front, for the progress indicator as much as -max-ips,
+	// and checked for -dry-run too, which must refuse what the run would
+	opts.total, err = expectedIPs(cidrs, &opts)
+	if err == nil {
+		err = checkMaxIPs(opts.total, &opts)
+	}
+	if opts.jsonSummary != "" && err != nil {
+		handleError(writeSummary(opts.jsonSummary, len(inputs), st, nil, invalid, err))
+	}
+	handleError(err)
+
+	if opts.dryRun {
+		printDryRun(opts.total, file)
+		if len(invalid) > 0 {
+			printInvalid(invalid)
+			os.Exit(exitParse)
+		}
+		return
+	}
+
+	if opts.output == "" && !opts.splitFiles {
+		handleError(os.MkdirAll(opts.outdir, 0755))
+	}
+
+	var files []string
+	if len(cidrs) > 0 {
+		// An interrupt stops the expansion cleanly instead of killing it mid-write.
+		// Only the first one, though: a second gets the default, immediate exit
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-ctx.Done()
+			stop()
+		}()
+
+		if opts.verbose {
+			opts.logger = newCIDRLogger(os.Stderr, start)
+		}
+
+		if opts.splitFiles {
+			files, err = writeSplitFiles(ctx, cidrs, &opts, st)
+		} else {
+			file, err = writeOutput(ctx, cidrs, file, &opts, st)
+			if err == nil {
+				files = []string{file}
+			}
+		}
+		if opts.jsonSummary != "" && err != nil {
+			handleError(writeSummary(opts.jsonSummary, len(inputs), st, files, invalid, err))
+		}
+		handleError(err)
+
+		if opts.logger != nil {
+			opts.logger.total()
+		}
+
+		if opts.stats {
+			handleError(st.print(os.Stderr))
+		}
+		if opts.splitFiles && !opts.quiet {
+			fmt.Println("IP lists saved to:")
+			for _, file := range files {
+				fmt.Printf("  %s\n", file)
+			}
+		}
+		// The message would end up mixed with the IP list when writing to stdout
+		if !opts.splitFiles && !isStdout(file) && !opts.quiet {
+			fmt.Printf("IP list saved to %s\n", file)
+		}
+	}
+
+	if opts.jsonSummary != "" {
+		handleError(writeSummary(opts.jsonSummary, len(inputs), st, files, invalid, nil))
+	}
+
+	if len(invalid) > 0 {
+		printInvalid(invalid)
+		os.Exit(exitParse)
+	}
+}
+
+// printInvalid reports every invalid CIDR found by checkCIDRs on stderr.
+func printInvalid(invalid []error) {
+	fmt.Fprintf(os.Stderr, "Error: %d invalid CIDR(s):\n", len(invalid))
+	for _, err := range invalid {
+		fmt.Fprintf(os.Stderr, "  %v\n", err)
+	}
+}
+
+func printHelp() {
+	fmt.Printf("Usage: %s [options] <CIDR1 CIDR2 ...>\n       ... | %s [options]\n       %s completion bash|zsh|fish\nOptions:\n", app, app, app)
+	flag.PrintDefaults()
+}
+
+func printVersion() {
+	fmt.Printf("%s version %s\n", app, version)
+}
+
+// expectedIPs returns how many IPs writing cidrs with opts will generate.
+// With -merge or -dedup, cidrs are rewritten first as writeOutput rewrites
+// them, so the addresses they share are only counted once.
+func expectedIPs(cidrs []string, opts *options) (*big.Int, error) {
+	var err error
+	switch {
+	case opts.merge:
+		cidrs, err = cidr2ip.MergeCIDRs(cidrs)
+	case opts.dedup:
+		cidrs, err = cidr2ip.DedupCIDRs(cidrs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	total := new(big.Int)
+	for _, cidr := range cidrs {
+		count, err := selectedIPs(cidr, opts)
+		if err != nil {
+			return nil, err
+		}
+		total.Add(total, count)
+	}
+
+	if limit := big.NewInt(int64(opts.limit)); opts.limit > 0 && total.Cmp(limit) > 0 {
+		total = limit
+	}
+
+	return total, nil
+}
+
+// selectedIPs returns how many IPs of cidr will actually be generated,
+// accounting for -boundaries, -only-network, -sample, -tail, -every and
+// -limit.
+func selectedIPs(cidr string, opts *options) (*big.Int, error) {
+	count, err := cidr2ip.CountCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	if two := big.NewInt(2); opts.boundaries && count.Cmp(two) > 0 {
+		count = two
+	}
+	if opts.onlyNetwork {
+		count = big.NewInt(1)
+	}
+	if k := big.NewInt(int64(opts.sample)); opts.sample > 0 && count.Cmp(k) > 0 {
+		count = k
+	}
+	if k := big.NewInt(int64(opts.tail)); opts.tail > 0 && count.Cmp(k) > 0 {
+		count = k
+	}
+	if opts.every > 1 {
+		k := big.NewInt(int64(opts.every))
+		count.Add(count, k).Sub(count, big.NewInt(1)).Div(count, k)
+	}
+	if limit := big.NewInt(int64(opts.limit)); opts.limit > 0 && count.Cmp(limit) > 0 {
+		count = limit
+	}
+
+	return count, nil
+}
+
+// checkMaxIPs fails if total, the number of IPs a run is expected to write as
+// expectedIPs computes it, is more than -max-ips. As it comes from the CIDR
+// sizes, a /0 or almost any IPv6 prefix, which would run for ages, is refused
+// before the expansion even starts.
+func checkMaxIPs(total *big.Int, opts *options) error {
+	if opts.maxIPs == 0 {
+		return nil
+	}
+
+	if total.Cmp(new(big.Int).SetUint64(opts.maxIPs)) > 0 {
+		return withCode(exitUsage, fmt.Errorf("the input has %s IPs in total, more than -max-ips %d allows", total, opts.maxIPs))
+	}
+
+	return nil
+}
+
+// printCounts writes a table of the number of IPs of each of cidrs, and their
+// total, to w. With usable set, only the usable hosts are counted, as
+// cidr2ip.UsableCount does.
+func printCounts(cidrs []string, usable bool, w io.Writer) error {
+	countOf := cidr2ip.CountCIDR
+	header := "CIDR\tIPs"
+	if usable {
+		countOf, header = cidr2ip.UsableCount, "CIDR\tHosts"
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, header)
+
+	total := new(big.Int)
+	for _, cidr := range cidrs {
+		count, err := countOf(cidr)
+		if err != nil {
+			return withCode(exitParse, err)
+		}
+		total.Add(total, count)
+		fmt.Fprintf(tw, "%s\t%s\n", cidr, count)
+	}
+
+	fmt.Fprintf(tw, "Total\t%s\n", total)
+	return tw.Flush()
+}
+
+// writeCountPerCIDR writes a CSV table of the size and usable hosts of each
+// of cidrs, computed from their bounds alone, to the -o file or else stdout.
+func writeCountPerCIDR(cidrs []string, opts *options) error {
+	rows := [][]string{withMask([]string{"cidr", "ips", "usable"}, "mask", opts)}
+	for _, cidr := range cidrs {
+		count, err := cidr2ip.CountCIDR(cidr)
+		if err != nil {
+			return withCode(exitParse, err)
+		}
+		usable, err := cidr2ip.UsableCount(cidr)
+		if err != nil {
+			return withCode(exitParse, err)
+		}
+		rows = append(rows, withMask([]string{cidr, count.String(), usable.String()}, formatMask(cidr, opts.maskFormat), opts))
+	}
+
+	return writeTable(rows, "Counts", opts)
+}
+
+// usableRange returns the network, first and last usable, and broadcast
+// addresses of cidr, by the same rules as cidr2ip.UsableCount. Only IPv4
+// CIDRs of more than two addresses have a network and broadcast address set
+// apart; for the rest, the usable range is the whole block.
+func usableRange(cidr string) (network, first, last, broadcast netip.Addr, err error) {
+	r, err := parseAddrRange(cidr)
+	if err != nil {
+		return
+	}
+	network, broadcast = r.first, r.last
+	if strings.Contains(cidr, "/") && !strings.Contains(cidr, "-") {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return network, first, last, broadcast, err
+		}
+		network, _ = netip.AddrFromSlice(cidr2ip.NetworkAddr(ipnet))
+		broadcast, _ = netip.AddrFromSlice(cidr2ip.BroadcastAddr(ipnet))
+		network, broadcast = network.Unmap(), broadcast.Unmap()
+	}
+
+	first, last = network, broadcast
+	if ones, bits := prefixBits(cidr); bits == 32 && ones < 31 {
+		first, last = network.Next(), broadcast.Prev()
+	}
+
+	return network, first, last, broadcast, nil
+}
+
+// prefixBits returns the prefix length of the CIDR notation cidr and the bit
+// length of its addresses, both 0 for ranges and bare IPs. IPv4-mapped blocks
+// count as IPv4.
+func prefixBits(cidr string) (ones, bits int) {
+	if !strings.Contains(cidr, "/") || strings.Contains(cidr, "-") {
+		return 0, 0
+	}
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return 0, 0
+	}
+
+	ones, bits = prefix.Bits(), prefix.Addr().BitLen()
+	if prefix.Addr().Is4In6() && ones >= 96 {
+		ones, bits = ones-96, 32
+	}
+	return ones, bits
+}
+
+// withMask returns row with mask inserted after its first field, the CIDR,
+// when -mask-format asks for the column.
+func withMask(row []string, mask string, opts *options) []string {
+	if opts.maskFormat == "" {
+		return row
+	}
+
+	return slices.Insert(row, 1, mask)
+}
+
+// formatMask returns the mask of the CIDR notation cidr in format: dotted, as
+// in 255.255.240.0 for a /20, or prefix, as in /20. IPv6 masks are dotted as
+// an address, such as ffff:ffff:ffff:ffff:: for a /64, and IPv4-mapped blocks
+// as the IPv4 mask. A bare IP has a full-length mask, and a range none.
+func formatMask(cidr, format string) string {
+	var ones, bits int
+	switch {
+	case strings.Contains(cidr, "-") || strings.Contains(cidr, "+"):
+		return ""
+	case strings.Contains(cidr, "/"):
+		if ones, bits = prefixBits(cidr); bits == 0 {
+			return ""
+		}
+	default:
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return ""
+		}
+		ones = addr.Unmap().BitLen()
+		bits = ones
+	}
+
+	if format == "prefix" {
+		return fmt.Sprintf("/%d", ones)
+	}
+	return net.IP(net.CIDRMask(ones, bits)).String()
+}
+
+// writePrefixSummary writes a CSV table with one line per CIDR of cidrs: its
+// network, first and last usable, and broadcast addresses, and the number of
+// usable hosts. Nothing is expanded, so any block size is fine.
+func writePrefixSummary(cidrs []string, opts *options) error {
+	rows := [][]string{withMask([]string{"cidr", "network", "first_usable", "last_usable", "broadcast", "hosts"}, "mask", opts)}
+	for _, cidr := range cidrs {
+		network, first, last, broadcast, err := usableRange(cidr)
+		if err != nil {
+			return withCode(exitParse, err)
+		}
+		hosts, err := cidr2ip.UsableCount(cidr)
+		if err != nil {
+			return withCode(exitParse, err)
+		}
+		row := []string{cidr, network.String(), first.String(), last.String(), broadcast.String(), hosts.String()}
+		rows = append(rows, withMask(row, formatMask(cidr, opts.maskFormat), opts))
+	}
+
+	return writeTable(rows, "Summary", opts)
+}
+
+// writeTable writes rows as CSV to the -o file or else stdout. When a file is
+// written, a message saying so, starting with what, is printed.
+func writeTable(rows [][]string, what string, opts *options) error {
+	var (
+		out io.Writer = os.Stdout
+		f   *outputFile
+		err error
+	)
+	file := opts.output
+	if file != "" && !isStdout(file) {
+		if f, err = openOutput(file, opts); err != nil {
+			return err
+		}
+		defer f.abort()
+		out = f
+	}
+
+	cw := csv.NewWriter(out)
+	cw.Comma = opts.comma
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	if f != nil {
+		if file, err = f.commit(); err != nil {
+			return err
+		}
+	}
+
+	if out != os.Stdout && !opts.quiet {
+		fmt.Printf("%s saved to %s\n", what, file)
+	}
+	return nil
+}
+
+// printUniqueCount writes to w the number of distinct addresses covered by
+// cidrs. They are merged first, as with -merge, so overlapping blocks are only
+// counted once.
+func printUniqueCount(inputs []cidrInput, w io.Writer) error {
+	merged, err := cidr2ip.MergeCIDRs(cidrStrings(inputs))
+	if err != nil {
+		return withCode(exitParse, locate(err, inputs))
+	}
+
+	total := new(big.Int)
+	for _, cidr := range merged {
+		count, err := cidr2ip.CountCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		total.Add(total, count)
+	}
+
+	_, err = fmt.Fprintln(w, total)
+	return err
+}
+
+// printSplit writes the subnets of length prefix (such as "/24") contained
+// in each input to w, one per line.
+func printSplit(inputs []cidrInput, prefix string, w io.Writer) error {
+	newPrefix, err := strconv.Atoi(strings.TrimPrefix(prefix, "/"))
+	if err != nil {
+		return withCode(exitUsage, fmt.Errorf("invalid prefix length: %s", prefix))
+	}
+
+	buf := bufio.NewWriter(w)
+	for _, in := range inputs {
+		subnets, err := cidr2ip.SplitCIDR(strings.TrimSpace(in.cidr), newPrefix)
+		if err != nil {
+			return withCode(exitParse, fmt.Errorf("%s: %w", in.position(), err))
+		}
+		for _, subnet := range subnets {
+			fmt.Fprintln(buf, subnet)
+		}
+	}
+
+	return buf.Flush()
+}
+
+// printDryRun reports that a run would write total IPs to file, as
+// expectedIPs computes them from the CIDR sizes without expanding them.
+func printDryRun(total *big.Int, file string) {
+	if isStdout(file) {
+		file = "stdout"
+	}
+	fmt.Printf("Would write %s IPs to %s\n", total, file)
+}
+
+// printWildcards writes the network address and wildcard mask of each CIDR
+// to w, one pair per line, ready to paste into an ACL.
+func printWildcards(inputs []cidrInput, w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	for _, in := range inputs {
+		first, last, err := cidr2ip.ParseRange(strings.TrimSpace(in.cidr))
+		if err != nil {
+			return withCode(exitParse, fmt.Errorf("%s: %w", in.position(), err))
+		}
+
+		// The bits the bounds share make the mask, which is only valid if
+		// the entry covers a single block
+		mask := make(net.IPMask, len(first))
+		for i := range mask {
+			mask[i] = ^(first[i] ^ last[i])
+		}
+		if _, bits := mask.Size(); bits == 0 || !first.Equal(first.Mask(mask)) {
+			return withCode(exitParse, fmt.Errorf("%s: %s is not a single CIDR block", in.position(), in.cidr))
+		}
+		ipnet := &net.IPNet{IP: first, Mask: mask}
+		fmt.Fprintln(buf, cidr2ip.NetworkAddr(ipnet), cidr2ip.WildcardMask(ipnet))
+	}
+
+	return buf.Flush()
+}
+
+// printContaining writes each of inputs that contains ip to w, one per line
+// and as given. It fails if any is invalid, or if none contains ip, so scripts
+// can test membership by the exit status alone.
+func printContaining(inputs []cidrInput, ip string, w io.Writer) error {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return withCode(exitUsage, err)
+	}
+	addr = addr.Unmap()
+
+	buf := bufio.NewWriter(w)
+	found := 0
+	for _, in := range inputs {
+		r, err := parseAddrRange(strings.TrimSpace(in.cidr))
+		if err != nil {
+			return withCode(exitParse, fmt.Errorf("%s: %w", in.position(), err))
+		}
+		if r.contains(addr) {
+			fmt.Fprintln(buf, in.cidr)
+			found++
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+
+	if found == 0 {
+		return fmt.Errorf("no input CIDR contains %s", addr)
+	}
+
+	return nil
+}
+
+// printCollapsed writes the smallest set of CIDRs covering the IPs of inputs
+// to w, one per line. The inputs may be rows of an earlier run's output: only
+// their first field, up to comma or a space, is read, and a header is skipped.
+func printCollapsed(inputs []cidrInput, comma rune, w io.Writer) error {
+	ips := make([]string, 0, len(inputs))
+	for _, in := range inputs {
+		ip, _, _ := strings.Cut(in.cidr, string(comma))
+		ip, _, _ = strings.Cut(strings.TrimSpace(ip), " ")
+		if ip == "ip" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(ip); err != nil || addr.Zone() != "" {
+			return withCode(exitParse, fmt.Errorf("%s: invalid IP address: %s", in.position(), ip))
+		}
+		ips = append(ips, ip)
+	}
+
+	cidrs, err := cidr2ip.CollapseIPs(ips)
+	if err != nil {
+		return withCode(exitParse, err)
+	}
+
+	buf := bufio.NewWriter(w)
+	for _, cidr := range cidrs {
+		fmt.Fprintln(buf, cidr)
+	}
+
+	return buf.Flush()
+}
+
+// handleError prints err and exits with the code exitCode picks for it.
+func handleError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err))
+	}
+}
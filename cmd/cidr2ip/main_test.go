@@ -0,0 +1,3580 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const binPath = "./cidr2ip"
+
+func TestCmdArguments(t *testing.T) {
+	buildBinary(t)
+
+	// Test with a single CIDR as a command-line argument
+	file1 := checkCmdOutput(t, binPath, "10.0.0.0/24")
+
+	// Test with multiple CIDRs as command-line arguments
+	file2 := checkCmdOutput(t, binPath, "10.0.1.0/24", "172.16.16.0/20", "192.168.0.0/16")
+
+	// Test with no CIDRs provided
+	checkError(t, binPath)
+
+	if file1 == file2 {
+		removeFiles(t, file1)
+	} else {
+		removeFiles(t, file1, file2)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	buildBinary(t)
+
+	// Test that every shell gets a script covering the flags and formats
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		output, err := runCommand(binPath, "completion", shell)
+		if err != nil {
+			t.Fatalf("completion %s failed with error: %v", shell, err)
+		}
+		for _, expected := range []string{"cidr2ip", "format", "outdir", "parquet"} {
+			if !strings.Contains(output, expected) {
+				t.Errorf("completion %s: expected %q in the script, got %q instead.", shell, expected, output)
+			}
+		}
+	}
+
+	// Test with an unsupported shell and a missing one
+	checkError(t, binPath, "completion", "tcsh")
+	checkError(t, binPath, "completion")
+
+	removeFiles(t)
+}
+
+func TestFileInput(t *testing.T) {
+	buildBinary(t)
+
+	// Test with cidr_list, the sample CIDR file at the repository root
+	file1 := checkCmdOutput(t, binPath, "-f", "../../cidr_list")
+
+	// Test with a non-existent file
+	checkError(t, binPath, "-f", "nonexistent_file.txt")
+
+	// Test with an empty file
+	file2 := "empty_file.txt"
+	if err := createEmptyFile(file2); err != nil {
+		t.Fatalf("Failed to create empty file: %v", err)
+	}
+	checkError(t, binPath, "-f", file2)
+
+	removeFiles(t, file1, file2)
+}
+
+func TestGzipInput(t *testing.T) {
+	buildBinary(t)
+
+	// Test that a compressed CIDR file is read like a plain one
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("# compressed\n10.0.0.0/31\n10.0.1.0/31\n"))
+	gz.Close()
+
+	file := "cidrs.txt.gz"
+	if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to create CIDR file: %v", err)
+	}
+
+	output, err := runCommand(binPath, "-o", "-", "-f", file)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "10.0.0.0\n10.0.0.1\n10.0.1.0\n10.0.1.1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that a truncated stream is an I/O error
+	if err := os.WriteFile(file, buf.Bytes()[:buf.Len()-8], 0644); err != nil {
+		t.Fatalf("Failed to create CIDR file: %v", err)
+	}
+	var exitErr *exec.ExitError
+	if _, err := runCommand(binPath, "-o", "-", "-f", file); !errors.As(err, &exitErr) || exitErr.ExitCode() != exitIO {
+		t.Errorf("Expected exit status %d, got %v instead.", exitIO, err)
+	}
+
+	removeFiles(t, file)
+}
+
+func TestURLInput(t *testing.T) {
+	buildBinary(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/subnets.txt", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "# canonical list\n10.0.0.0/31\n\n192.168.0.0/31\n")
+	})
+	mux.HandleFunc("/slow.txt", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// Test reading the CIDRs served at a URL
+	output, err := runCommand(binPath, "-o", "-", "-f", server.URL+"/subnets.txt")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v: %s", err, output)
+	}
+
+	expected := "10.0.0.0\n10.0.0.1\n192.168.0.0\n192.168.0.1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that a response other than 200 fails clearly
+	output, err = runCommand(binPath, "-o", "-", "-f", server.URL+"/missing.txt")
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitIO {
+		t.Errorf("Expected exit code %d, got %v instead.", exitIO, err)
+	}
+	if !strings.Contains(output, "404 Not Found") {
+		t.Errorf("Expected the status in the error, got %q instead.", output)
+	}
+
+	// Test that -timeout bounds a slow server
+	start := time.Now()
+	if _, err := runCommand(binPath, "-timeout", "200ms", "-o", "-", "-f", server.URL+"/slow.txt"); err == nil {
+		t.Error("Expected an error, but command succeeded.")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Expected the fetch to time out quickly, took %s instead.", elapsed)
+	}
+
+	removeFiles(t)
+}
+
+func TestFileComments(t *testing.T) {
+	buildBinary(t)
+
+	// Test with a file mixing comments, blank lines and valid CIDRs
+	file := "commented_cidrs.txt"
+	content := "# staging subnets\n10.0.0.0/31\n\n   \n  # prod subnets\n192.168.0.0/31 # prod\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create CIDR file: %v", err)
+	}
+
+	output, err := runCommand(binPath, "-o", "-", "-f", file)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "10.0.0.0\n10.0.0.1\n192.168.0.0\n192.168.0.1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test with a file containing only comments
+	commentsOnly := "comments_only.txt"
+	if err := os.WriteFile(commentsOnly, []byte("# nothing here\n\n"), 0644); err != nil {
+		t.Fatalf("Failed to create CIDR file: %v", err)
+	}
+	checkError(t, binPath, "-f", commentsOnly)
+
+	removeFiles(t, file, commentsOnly)
+}
+
+func TestMultipleFiles(t *testing.T) {
+	buildBinary(t)
+
+	// Test that repeated -f files are read in order
+	prod, staging := "prod_cidrs.txt", "staging_cidrs.txt"
+	if err := os.WriteFile(prod, []byte("192.168.0.0/31\n"), 0644); err != nil {
+		t.Fatalf("Failed to create CIDR file: %v", err)
+	}
+	if err := os.WriteFile(staging, []byte("10.0.0.0/31\n"), 0644); err != nil {
+		t.Fatalf("Failed to create CIDR file: %v", err)
+	}
+
+	output, err := runCommand(binPath, "-o", "-", "-f", prod, "-f", staging)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "192.168.0.0\n192.168.0.1\n10.0.0.0\n10.0.0.1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that arguments are expanded after the files
+	output, err = runCommand(binPath, "-o", "-", "-f", prod, "10.5.0.0/31")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected = "192.168.0.0\n192.168.0.1\n10.5.0.0\n10.5.0.1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that a missing file among them is still an error
+	checkError(t, binPath, "-o", "-", "-f", prod, "-f", "missing_cidrs.txt")
+
+	removeFiles(t, prod, staging)
+}
+
+func TestIncludeFiles(t *testing.T) {
+	buildBinary(t)
+
+	// Test that @ lines include other files, relative to the including one,
+	// in place and recursively
+	dir := t.TempDir()
+	files := map[string]string{
+		"manifest.txt":         "# every site\n10.9.0.0/31\n@sites/prod.txt\n@ sites/lab.txt # trailing comment\n",
+		"sites/prod.txt":       "192.168.0.0/31\n",
+		"sites/lab.txt":        "@shared/lab.txt\n10.1.0.0/32\n",
+		"sites/shared/lab.txt": "10.0.0.0/31\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create CIDR file: %v", err)
+		}
+	}
+
+	output, err := runCommand(binPath, "-o", "-", "-f", filepath.Join(dir, "manifest.txt"))
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	expected := "10.9.0.0\n10.9.0.1\n192.168.0.0\n192.168.0.1\n10.0.0.0\n10.0.0.1\n10.1.0.0\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that errors in an included file point at it and the include chain
+	bad := filepath.Join(dir, "bad.txt")
+	if err := os.WriteFile(bad, []byte("@sites/prod.txt\n@sites/missing.txt\n"), 0644); err != nil {
+		t.Fatalf("Failed to create CIDR file: %v", err)
+	}
+	output, err = runCommand(binPath, "-o", "-", "-f", bad)
+	if err == nil || !strings.Contains(output, bad+":2:") || !strings.Contains(output, "missing.txt") {
+		t.Errorf("Expected an error at %s:2, got %q (%v) instead.", bad, output, err)
+	}
+
+	// Test that include cycles are caught instead of recursing forever
+	loopA, loopB := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	if err := os.WriteFile(loopA, []byte("10.0.0.0/32\n@b.txt\n"), 0644); err != nil {
+		t.Fatalf("Failed to create CIDR file: %v", err)
+	}
+	if err := os.WriteFile(loopB, []byte("@./a.txt\n"), 0644); err != nil {
+		t.Fatalf("Failed to create CIDR file: %v", err)
+	}
+	output, err = runCommand(binPath, "-o", "-", "-f", loopA)
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitUsage || !strings.Contains(output, "include cycle") {
+		t.Errorf("Expected an include cycle error, got %q (%v) instead.", output, err)
+	}
+
+	// Test that a file included twice, but not by itself, is no cycle
+	if err := os.WriteFile(loopB, []byte("@sites/prod.txt\n@sites/prod.txt\n"), 0644); err != nil {
+		t.Fatalf("Failed to create CIDR file: %v", err)
+	}
+	output, err = runCommand(binPath, "-o", "-", "-f", loopB)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if expected := "192.168.0.0\n192.168.0.1\n192.168.0.0\n192.168.0.1\n"; output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	removeFiles(t)
+}
+
+func TestResolveInclude(t *testing.T) {
+	tests := []struct {
+		source, target, expected string
+	}{
+		{"lists/manifest.txt", "prod.txt", filepath.Join("lists", "prod.txt")},
+		{"lists/manifest.txt", "../shared/lab.txt", filepath.Join("shared", "lab.txt")},
+		{"lists/manifest.txt", "/etc/cidrs.txt", "/etc/cidrs.txt"},
+		{"lists/manifest.txt", "https://example.com/a.txt", "https://example.com/a.txt"},
+		{"https://example.com/lists/manifest.txt", "prod.txt", "https://example.com/lists/prod.txt"},
+		{"https://example.com/lists/manifest.txt", "/root.txt", "https://example.com/root.txt"},
+	}
+
+	for _, tt := range tests {
+		got, err := resolveInclude(tt.source, tt.target)
+		if err != nil {
+			t.Fatalf("resolveInclude(%s, %s) failed with error: %v", tt.source, tt.target, err)
+		}
+		if got != tt.expected {
+			t.Errorf("resolveInclude(%s, %s): expected %s, got %s instead.", tt.source, tt.target, tt.expected, got)
+		}
+	}
+
+	if _, err := resolveInclude("manifest.txt", ""); err == nil {
+		t.Error("Expected an error for an empty include, got nil instead.")
+	}
+}
+
+func TestStdinInput(t *testing.T) {
+	buildBinary(t)
+
+	// Test reading CIDRs piped through stdin, with blank lines in between
+	output, err := runCommandWithInput("10.0.0.0/31\n\n192.168.0.0/31\n\n", binPath, "-o", "-")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "10.0.0.0\n10.0.0.1\n192.168.0.0\n192.168.0.1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test with an empty pipe
+	if _, err := runCommandWithInput("", binPath); err == nil {
+		t.Error("Expected an error, but command succeeded.")
+	}
+
+	removeFiles(t)
+}
+
+func TestInvalidCIDRs(t *testing.T) {
+	buildBinary(t)
+
+	// Test with an invalid CIDR as a command-line argument
+	checkError(t, binPath, "10.0.0.0/33")
+
+	// Test with multiple CIDRs as command-line arguments, one of which is invalid
+	checkError(t, binPath, "10.0.0.0/24", "172.256.0.0/16", "192.168.0.0/16")
+
+	// Test that the error names the invalid CIDR and no output file is left behind
+	output, _ := runCommand(binPath, "10.0.0.0/24", "172.256.0.0/16")
+	if !strings.Contains(output, "172.256.0.0/16") {
+		t.Errorf("Expected error mentioning '172.256.0.0/16', got '%s' instead.", output)
+	}
+	if strings.Contains(output, "IP list saved to") {
+		t.Errorf("Expected no output file, got '%s' instead.", output)
+	}
+
+	// Test that an invalid CIDR is caught before the -o file is created
+	checkError(t, binPath, "-o", "invalid.csv", "10.0.0.0/24", "172.256.0.0/16")
+	if _, err := os.Stat("invalid.csv"); !os.IsNotExist(err) {
+		t.Errorf("Expected no output file for invalid input, but found one.")
+		os.Remove("invalid.csv")
+	}
+
+	// Test with a file containing one invalid CIDR
+	file := "invalid_cidr.txt"
+	if err := os.WriteFile(file, []byte("192.168.1.0/16.0"), 0644); err != nil {
+		t.Fatalf("Failed to create invalid CIDR file: %v", err)
+	}
+	checkError(t, binPath, "-f", file)
+
+	removeFiles(t, file)
+}
+
+func TestStrict(t *testing.T) {
+	buildBinary(t)
+
+	// Test that host bits are normalized away by default
+	output, err := runCommand(binPath, "-o", "-", "10.0.0.5/24")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 256 || lines[0] != "10.0.0.0" || lines[255] != "10.0.0.255" {
+		t.Errorf("Expected the full 10.0.0.0/24, got %d lines instead: %q", len(lines), output)
+	}
+
+	// Test that -strict rejects them and points at the network meant
+	output, err = runCommand(binPath, "-strict", "-o", "-", "10.0.0.5/24")
+	if err == nil {
+		t.Error("Expected an error, but command succeeded.")
+	}
+	if !strings.Contains(output, "10.0.0.0/24") {
+		t.Errorf("Expected the error to suggest 10.0.0.0/24, got %q instead.", output)
+	}
+
+	// Test that clean CIDRs, ranges and bare IPs still pass
+	if _, err := runCommand(binPath, "-strict", "-o", "-", "10.0.0.0/24", "10.0.0.5-10.0.0.9", "10.0.0.5", "2001:db8::/126"); err != nil {
+		t.Errorf("Command failed with error: %v", err)
+	}
+
+	removeFiles(t)
+}
+
+func TestNoDupCIDRs(t *testing.T) {
+	buildBinary(t)
+
+	file := "dups.txt"
+	content := "10.0.0.0/24\n192.168.0.0/30\n10.0.0.0/24 \n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create CIDR file: %v", err)
+	}
+
+	// Test that a repeated CIDR is reported with both positions
+	output, err := runCommand(binPath, "-no-dup-cidrs", "-o", "-", "-f", file, " 10.0.0.0/24")
+	if err == nil {
+		t.Error("Expected an error, but command succeeded.")
+	}
+	for _, expected := range []string{
+		"2 duplicate CIDR(s)",
+		"dups.txt:3: 10.0.0.0/24 repeats 10.0.0.0/24 from dups.txt:1",
+		"argument 1:  10.0.0.0/24 repeats 10.0.0.0/24 from dups.txt:1",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected %q in output, got %q instead.", expected, output)
+		}
+	}
+	if strings.Contains(output, "10.0.0.1\n") {
+		t.Errorf("Expected nothing to be expanded, got %q instead.", output)
+	}
+
+	// Test that -warn only warns and expands everything
+	output, err = runCommand(binPath, "-no-dup-cidrs", "-warn", "-o", "-", "-f", file)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if !strings.Contains(output, "Warning: duplicate CIDR: dups.txt:3") {
+		t.Errorf("Expected a warning, got %q instead.", output)
+	}
+	if n := strings.Count(output, "10.0.0.1\n"); n != 2 {
+		t.Errorf("Expected 10.0.0.1 twice, got it %d times instead.", n)
+	}
+
+	// Test that repeats are allowed by default
+	if _, err := runCommand(binPath, "-o", "-", "-f", file); err != nil {
+		t.Errorf("Command failed with error: %v", err)
+	}
+
+	removeFiles(t, file)
+}
+
+func TestIPRanges(t *testing.T) {
+	buildBinary(t)
+
+	// Test with a file mixing CIDRs and IP ranges
+	file := "ranges.txt"
+	content := "10.0.0.0/31\n10.0.0.254-10.0.1.1\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create CIDR file: %v", err)
+	}
+
+	output, err := runCommand(binPath, "-o", "-", "-f", file)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "10.0.0.0\n10.0.0.1\n10.0.0.254\n10.0.0.255\n10.0.1.0\n10.0.1.1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test with reversed and mixed-family ranges
+	checkError(t, binPath, "10.0.0.200-10.0.0.5")
+	checkError(t, binPath, "10.0.0.1-2001:db8::1")
+
+	removeFiles(t, file)
+}
+
+func TestStartCount(t *testing.T) {
+	buildBinary(t)
+
+	// Test a count crossing a .255 boundary
+	output, err := runCommand(binPath, "-o", "-", "10.0.0.253+5")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "10.0.0.253\n10.0.0.254\n10.0.0.255\n10.0.1.0\n10.0.1.1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test crossing two octets at once
+	output, err = runCommand(binPath, "-o", "-", "10.0.255.255+2")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected = "10.0.255.255\n10.1.0.0\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test a zero count and one running past the end of the address space
+	checkError(t, binPath, "-o", "-", "10.0.0.1+0")
+	checkError(t, binPath, "-o", "-", "255.255.255.254+3")
+
+	removeFiles(t)
+}
+
+func TestBareIPs(t *testing.T) {
+	buildBinary(t)
+
+	// Test that bare IPv4 and IPv6 addresses stand for a single host
+	output, err := runCommand(binPath, "-o", "-", "10.0.0.5", "10.0.1.0/31", "2001:db8::1", "::ffff:10.0.0.9")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "10.0.0.5\n10.0.1.0\n10.0.1.1\n2001:db8::1\n10.0.0.9\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that a malformed or zoned address is still rejected
+	checkError(t, binPath, "10.0.0.256")
+	checkError(t, binPath, "fe80::1%eth0")
+
+	removeFiles(t)
+}
+
+func TestKeepGoing(t *testing.T) {
+	buildBinary(t)
+
+	// Test with a file containing a mix of valid and invalid CIDRs
+	file := "mixed_cidrs.txt"
+	content := "10.0.0.0/31\n10.0.0.0/33\n192.168.0.0/31\n# comment\n172.256.0.0/16\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create CIDR file: %v", err)
+	}
+
+	output := "keep_going.csv"
+	stderr, err := runCommand(binPath, "-keep-going", "-o", output, "-f", file)
+	if err == nil {
+		t.Error("Expected an error, but command succeeded.")
+	}
+
+	// Test that every invalid CIDR is reported with its line number
+	for _, expected := range []string{"2 invalid CIDR(s)", file + ":2:", file + ":5:"} {
+		if !strings.Contains(stderr, expected) {
+			t.Errorf("Expected '%s' in output, got '%s' instead.", expected, stderr)
+		}
+	}
+
+	// Test that the valid CIDRs are still expanded
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Error reading file: %v", err)
+	}
+
+	expected := "10.0.0.0\n10.0.0.1\n192.168.0.0\n192.168.0.1\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, string(data))
+	}
+
+	removeFiles(t, file, output)
+}
+
+func TestCheck(t *testing.T) {
+	buildBinary(t)
+
+	before, _ := filepath.Glob(app + "_*")
+
+	// Test that valid input passes, however large, and nothing is written
+	output, err := runCommand(binPath, "-check", "10.0.0.0/8", "::/0", "10.0.0.5-10.0.0.9")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "3 CIDR(s) OK\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	after, _ := filepath.Glob(app + "_*")
+	if len(after) != len(before) {
+		t.Errorf("Expected no output file, got %v instead.", after)
+	}
+
+	// Test that every invalid entry is reported with its line number
+	file := "check_cidrs.txt"
+	content := "10.0.0.0/31\n10.0.0.0/33\n# comment\n172.256.0.0/16\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create CIDR file: %v", err)
+	}
+
+	output, err = runCommand(binPath, "-check", "-f", file)
+	if err == nil {
+		t.Error("Expected an error, but command succeeded.")
+	}
+	for _, expected := range []string{"2 invalid CIDR(s)", file + ":2:", file + ":4:"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected '%s' in output, got '%s' instead.", expected, output)
+		}
+	}
+
+	removeFiles(t, file)
+}
+
+func TestExitCodes(t *testing.T) {
+	buildBinary(t)
+
+	existing := "exit_codes.csv"
+	if err := createEmptyFile(existing); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"no input", nil, 2},
+		{"bad flag value", []string{"-j", "0", "10.0.0.0/30"}, 2},
+		{"bad CIDR", []string{"-o", "-", "10.0.0.0/33"}, 3},
+		{"bad CIDR with -keep-going", []string{"-keep-going", "-o", "-", "10.0.0.0/30", "10.0.0.0/33"}, 3},
+		{"missing file", []string{"-f", "missing_cidrs.txt"}, 4},
+		{"existing output", []string{"-o", existing, "10.0.0.0/30"}, 4},
+	}
+
+	// Test that each kind of failure has its own exit code
+	for _, tt := range tests {
+		cmd := exec.Command(binPath, tt.args...)
+		err := cmd.Run()
+
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			t.Errorf("%s: expected exit code %d, got %v instead.", tt.name, tt.code, err)
+			continue
+		}
+		if code := exitErr.ExitCode(); code != tt.code {
+			t.Errorf("%s: expected exit code %d, got %d instead.", tt.name, tt.code, code)
+		}
+	}
+
+	removeFiles(t, existing)
+}
+
+func TestDryRun(t *testing.T) {
+	buildBinary(t)
+
+	before, _ := filepath.Glob(app + "_*")
+
+	// Test that the file name and count are printed and nothing is written
+	output, err := runCommand(binPath, "-dry-run", "10.0.0.0/8", "192.168.1.0/24")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	if !regexp.MustCompile(`^Would write 16777472 IPs to cidr2ip_.+\.csv\n$`).MatchString(output) {
+		t.Errorf("Unexpected output: %q", output)
+	}
+
+	after, _ := filepath.Glob(app + "_*")
+	if len(after) != len(before) {
+		t.Errorf("Expected no output file, got %v instead.", after)
+	}
+
+	// Test that a block too large to expand can still be counted
+	output, err = runCommand(binPath, "-dry-run", "-o", "-", "-limit", "1000", "2001:db8::/32")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "Would write 1000 IPs to stdout\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that a run -max-ips would refuse is refused as well, unless disabled
+	output, err = runCommand(binPath, "-dry-run", "-o", "-", "::/0")
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitUsage || strings.Contains(output, "Would write") {
+		t.Errorf("Expected the -max-ips refusal, got %q (%v) instead.", output, err)
+	}
+	output, err = runCommand(binPath, "-dry-run", "-max-ips", "0", "-o", "-", "::/0")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if expected := "Would write 340282366920938463463374607431768211456 IPs to stdout\n"; output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that invalid input still fails
+	checkError(t, binPath, "-dry-run", "10.0.0.0/33")
+
+	removeFiles(t)
+}
+
+func TestCollapse(t *testing.T) {
+	buildBinary(t)
+
+	// Test collapsing the output of an earlier run back into its CIDRs
+	list, err := runCommand(binPath, "-with-cidr", "-header", "-o", "-", "10.0.0.0/24", "10.0.2.7", "10.0.1.0/25")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	output, err := runCommandWithInput(list, binPath, "-collapse")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "10.0.0.0/24\n10.0.1.0/25\n10.0.2.7/32\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that a line that isn't an IP is reported
+	checkError(t, binPath, "-collapse", "10.0.0.1", "10.0.0.0/24")
+
+	removeFiles(t)
+}
+
+func TestUniqueCount(t *testing.T) {
+	buildBinary(t)
+
+	tests := []struct {
+		cidrs    []string
+		expected string
+	}{
+		// Two /25s sharing all their addresses are only counted once
+		{[]string{"10.0.0.0/25", "10.0.0.0/25"}, "128\n"},
+		{[]string{"10.0.0.0/25", "10.0.0.64/26", "10.0.0.100-10.0.0.200"}, "201\n"},
+		{[]string{"2001:db8::/64", "2001:db8::/65", "10.0.0.0/8"}, "18446744073726328832\n"},
+	}
+
+	for _, tt := range tests {
+		output, err := runCommand(binPath, append([]string{"-unique-count"}, tt.cidrs...)...)
+		if err != nil {
+			t.Fatalf("Command failed with error: %v", err)
+		}
+		if output != tt.expected {
+			t.Errorf("%v: expected %q, got %q instead.", tt.cidrs, tt.expected, output)
+		}
+	}
+
+	// Test with an invalid CIDR, reported where it was read from
+	checkError(t, binPath, "-unique-count", "10.0.0.0/33")
+
+	output, err := runCommand(binPath, "-unique-count", "10.0.0.0/24", "10.0.0.0/33")
+	if err == nil {
+		t.Error("Expected an error, but command succeeded.")
+	}
+	if !strings.Contains(output, "argument 2: invalid CIDR address: 10.0.0.0/33") {
+		t.Errorf("Expected the error to point at argument 2, got %q instead.", output)
+	}
+
+	removeFiles(t)
+}
+
+func TestIPCount(t *testing.T) {
+	buildBinary(t)
+
+	// Test with a single CIDR as a command-line argument
+	file := checkCmdOutput(t, binPath, "172.16.18.0/20")
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Error reading file: %v", err)
+	}
+
+	count := strings.Count(string(data), "\n")
+
+	expected := 4096
+	if count != expected {
+		t.Errorf("Expected %d IP addresses, but found %d", expected, count)
+	}
+
+	removeFiles(t, file)
+}
+
+func TestJSONFormat(t *testing.T) {
+	buildBinary(t)
+
+	// Test with the JSON output format
+	output, err := runCommand(binPath, "-format", "json", "10.0.0.0/30")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	file := extractFileName(output, "IP list saved to (.+\\.json)")
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Error reading file: %v", err)
+	}
+
+	var ips []string
+	if err := json.Unmarshal(data, &ips); err != nil {
+		t.Fatalf("Invalid JSON output: %v", err)
+	}
+
+	expected := []string{"10.0.0.0", "10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	if strings.Join(ips, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected %v, got %v instead.", expected, ips)
+	}
+
+	// Test with an unsupported format
+	checkError(t, binPath, "-format", "xml", "10.0.0.0/30")
+
+	removeFiles(t, file)
+}
+
+func TestNDJSONFormat(t *testing.T) {
+	buildBinary(t)
+
+	// Test that every line is a JSON object on its own
+	output, err := runCommand(binPath, "-format", "ndjson", "-with-cidr", "-int", "10.0.0.0/31")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	file := extractFileName(output, "IP list saved to (.+\\.ndjson)")
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Error reading file: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	expected := []map[string]string{
+		{"ip": "10.0.0.0", "cidr": "10.0.0.0/31", "int": "167772160"},
+		{"ip": "10.0.0.1", "cidr": "10.0.0.0/31", "int": "167772161"},
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %q instead.", len(expected), string(data))
+	}
+	for i, line := range lines {
+		var row map[string]string
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			t.Fatalf("Invalid JSON line %q: %v", line, err)
+		}
+		for key, value := range expected[i] {
+			if row[key] != value {
+				t.Errorf("Expected %s %q on line %d, got %q instead.", key, value, i+1, row[key])
+			}
+		}
+	}
+
+	removeFiles(t, file)
+}
+
+func TestHostsFormat(t *testing.T) {
+	buildBinary(t)
+
+	// Test the default and a custom host name prefix
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{nil, "10.0.0.4 host-10-0-0-4\n10.0.0.5 host-10-0-0-5\n"},
+		{[]string{"-hostname-prefix", "lab"}, "10.0.0.4 lab10-0-0-4\n10.0.0.5 lab10-0-0-5\n"},
+	}
+
+	for _, tt := range tests {
+		args := append(append([]string{"-format", "hosts", "-o", "-"}, tt.args...), "10.0.0.4/31")
+		output, err := runCommand(binPath, args...)
+		if err != nil {
+			t.Fatalf("Command failed with error: %v", err)
+		}
+		if output != tt.expected {
+			t.Errorf("Expected %q, got %q instead.", tt.expected, output)
+		}
+	}
+
+	removeFiles(t)
+}
+
+func TestHostname(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"10.0.0.5", "host-10-0-0-5"},
+		{"192.168.100.254", "host-192-168-100-254"},
+		{"2001:db8::1", "host-2001-0db8-0000-0000-0000-0000-0000-0001"},
+		{"2001:db8::", "host-2001-0db8-0000-0000-0000-0000-0000-0000"},
+		{"::ffff:10.0.0.1", "host-0000-0000-0000-0000-0000-ffff-0a00-0001"},
+	}
+
+	for _, tt := range tests {
+		if got := hostname("host-", tt.ip); got != tt.expected {
+			t.Errorf("hostname(%s): expected %s, got %s instead.", tt.ip, tt.expected, got)
+		}
+	}
+}
+
+func TestTextFormat(t *testing.T) {
+	buildBinary(t)
+
+	// Test the plain text output format
+	output, err := runCommand(binPath, "-format", "txt", "10.0.0.0/31", "2001:db8::/127")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	file := extractFileName(output, "IP list saved to (.+\\.txt)")
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Error reading file: %v", err)
+	}
+
+	// Every line, including the last, ends in a newline and nothing is quoted
+	expected := "10.0.0.0\n10.0.0.1\n2001:db8::\n2001:db8::1\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, string(data))
+	}
+	if strings.ContainsAny(string(data), `"'`) {
+		t.Errorf("Expected no quoting, got %q instead.", string(data))
+	}
+
+	removeFiles(t, file)
+}
+
+func TestInlineFormat(t *testing.T) {
+	buildBinary(t)
+
+	// Test that the IPs are joined on one line with no trailing comma
+	output, err := runCommand(binPath, "-format", "inline", "-o", "-", "10.0.0.0/30", "2001:db8::/127")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "10.0.0.0,10.0.0.1,10.0.0.2,10.0.0.3,2001:db8::,2001:db8::1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+	if strings.HasSuffix(strings.TrimSuffix(output, "\n"), ",") {
+		t.Errorf("Expected no trailing comma, got %q instead.", output)
+	}
+
+	// Test a list large enough to span several buffer flushes
+	output, err = runCommand(binPath, "-format", "inline", "-o", "-", "10.0.0.0/16")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if strings.Count(output, "\n") != 1 || strings.Count(output, ",") != 65535 || strings.HasSuffix(output, ",\n") {
+		t.Errorf("Expected 65536 comma-separated IPs on one line, got %d commas and %d newlines instead.",
+			strings.Count(output, ","), strings.Count(output, "\n"))
+	}
+
+	removeFiles(t)
+}
+
+func TestNmapFormat(t *testing.T) {
+	buildBinary(t)
+
+	// Test that only the bare IPs are written, even with other columns selected
+	file := "targets.nmap"
+	_, err := runCommand(binPath, "-format", "nmap", "-header", "-with-cidr", "-int", "-o", file, "10.0.0.0/31", "2001:db8::/127")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Error reading file: %v", err)
+	}
+
+	expected := "10.0.0.0\n10.0.0.1\n2001:db8::\n2001:db8::1\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, string(data))
+	}
+
+	// Lines end in a lone LF whatever platform the binary was built on, and
+	// nothing such as a byte order mark precedes the first address
+	if bytes.Contains(data, []byte("\r")) {
+		t.Errorf("Expected LF line endings only, got %q instead.", string(data))
+	}
+	if bytes.HasPrefix(data, []byte("\xef\xbb\xbf")) {
+		t.Errorf("Expected no byte order mark, got %q instead.", string(data))
+	}
+
+	removeFiles(t, file)
+}
+
+func TestSQLFormat(t *testing.T) {
+	buildBinary(t)
+
+	// Test that rows are batched into INSERT statements of at most -sql-batch rows
+	output, err := runCommand(binPath, "-format", "sql", "-table", "public.hosts", "-sql-batch", "2", "-o", "-", "10.0.0.0/30", "10.0.1.0/32")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	expected := "INSERT INTO public.hosts (\"ip\") VALUES ('10.0.0.0'), ('10.0.0.1');\n" +
+		"INSERT INTO public.hosts (\"ip\") VALUES ('10.0.0.2'), ('10.0.0.3');\n" +
+		"INSERT INTO public.hosts (\"ip\") VALUES ('10.0.1.0');\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that every selected column is inserted and each line is a statement
+	output, err = runCommand(binPath, "-format", "sql", "-with-cidr", "-o", "-", "10.0.0.0/31")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	expected = "INSERT INTO ips (\"ip\", \"cidr\") VALUES ('10.0.0.0', '10.0.0.0/31'), ('10.0.0.1', '10.0.0.0/31');\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+	statement := regexp.MustCompile(`^INSERT INTO [a-z_.]+ \("[a-z]+"(, "[a-z]+")*\) VALUES \('[^']*'(, '[^']*')*\)(, \('[^']*'(, '[^']*')*\))*;$`)
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		if !statement.MatchString(line) {
+			t.Errorf("Expected an INSERT statement, got %q instead.", line)
+		}
+	}
+
+	// Test that column names which are keywords, such as offset, are quoted
+	output, err = runCommand(binPath, "-format", "sql", "-columns", "ip,offset,int", "-o", "-", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if expected := "INSERT INTO ips (\"ip\", \"offset\", \"int\") VALUES ('10.0.0.1', '0', '167772161');\n"; output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that table names needing quoting and empty batches are rejected
+	for _, args := range [][]string{
+		{"-table", "ips; DROP TABLE users"},
+		{"-table", "1ips"},
+		{"-table", `"ips"`},
+		{"-sql-batch", "0"},
+	} {
+		args = append(append([]string{"-format", "sql", "-o", "-"}, args...), "10.0.0.0/31")
+		_, err := runCommand(binPath, args...)
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitUsage {
+			t.Errorf("Expected exit status %d for %q, got %v instead.", exitUsage, args, err)
+		}
+	}
+
+	removeFiles(t)
+}
+
+func TestSQLQuote(t *testing.T) {
+	for value, expected := range map[string]string{
+		"10.0.0.1":  "'10.0.0.1'",
+		"":          "''",
+		"it's":      "'it''s'",
+		"a'); DROP": "'a''); DROP'",
+	} {
+		if got := sqlQuote(value); got != expected {
+			t.Errorf("sqlQuote(%q) = %q, expected %q", value, got, expected)
+		}
+	}
+
+	for name, expected := range map[string]string{
+		"offset": `"offset"`,
+		`a"b`:    `"a""b"`,
+	} {
+		if got := sqlQuoteIdentifier(name); got != expected {
+			t.Errorf("sqlQuoteIdentifier(%q) = %q, expected %q", name, got, expected)
+		}
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	buildBinary(t)
+
+	// Test that each IP is written as the template executed with its fields
+	output, err := runCommand(binPath, "-template", "server {{.IP}} { address {{.IP}}; } # {{.Index}} {{.CIDR}} {{.Int}}", "-o", "-", "10.0.0.0/31", "192.168.1.7")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	expected := "server 10.0.0.0 { address 10.0.0.0; } # 0 10.0.0.0/31 167772160\n" +
+		"server 10.0.0.1 { address 10.0.0.1; } # 1 10.0.0.0/31 167772161\n" +
+		"server 192.168.1.7 { address 192.168.1.7; } # 2 192.168.1.7 3232235783\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that only the columns the template uses are worked out by default
+	output, err = runCommand(binPath, "-template", "{{with .Hex}}{{.}}{{end}} {{$.Class}} {{template \"x\" .}}{{define \"x\"}}{{.Offset}}{{end}}", "-o", "-", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if expected := "0A000001 private 0\n"; output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+	tmpl, err := parseTemplate("{{.Int}} {{.Index}}")
+	if err != nil {
+		t.Fatalf("parseTemplate failed with error: %v", err)
+	}
+	columns, err := parseColumns(&options{format: "template", tmpl: tmpl})
+	if got := fmt.Sprint(columns); err != nil || got != "[ip int]" {
+		t.Errorf("Expected [ip int], got %s (%v) instead.", got, err)
+	}
+
+	// Test that -columns picks the fields filled in
+	output, err = runCommand(binPath, "-template", "{{.IP}}|{{.Mapped}}|{{.CIDR}}", "-columns", "ip,mapped", "-o", "-", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if expected := "10.0.0.1|::ffff:10.0.0.1|\n"; output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that bad templates fail up front, before any file is created
+	file := "template.out"
+	for _, tmpl := range []string{"{{.IP", "{{.Hostname}}"} {
+		output, err := runCommand(binPath, "-template", tmpl, "-o", file, "10.0.0.0/31")
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitUsage || !strings.Contains(output, "invalid -template") {
+			t.Errorf("%s: expected an invalid template error, got %q (%v) instead.", tmpl, output, err)
+		}
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("%s: expected no %s, got %v instead.", tmpl, file, err)
+		}
+	}
+
+	checkError(t, binPath, "-template", "{{.IP}}", "-format", "csv", "-o", "-", "10.0.0.0/31")
+	checkError(t, binPath, "-format", "template", "-o", "-", "10.0.0.0/31")
+
+	removeFiles(t)
+}
+
+func TestEnvDefaults(t *testing.T) {
+	buildBinary(t)
+
+	run := func(env string, args ...string) string {
+		cmd := exec.Command(binPath, args...)
+		cmd.Env = append(os.Environ(), env)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("Command failed with error: %v: %s", err, output)
+		}
+		return string(output)
+	}
+
+	// Test that CIDR2IP_FORMAT sets the default format
+	output := run("CIDR2IP_FORMAT=json", "-o", "-", "10.0.0.0/31")
+	if expected := `["10.0.0.0","10.0.0.1"]` + "\n"; output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that an explicit -format wins over it
+	output = run("CIDR2IP_FORMAT=json", "-format", "txt", "-o", "-", "10.0.0.0/31")
+	if expected := "10.0.0.0\n10.0.0.1\n"; output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that CIDR2IP_OUTDIR sets where -split-files writes
+	dir := t.TempDir()
+	run("CIDR2IP_OUTDIR="+dir, "-q", "-split-files", "10.0.0.0/31")
+	if _, err := os.Stat(filepath.Join(dir, "10.0.0.0_31.csv")); err != nil {
+		t.Errorf("Expected the file in %s: %v", dir, err)
+	}
+
+	removeFiles(t)
+}
+
+func TestStdoutOutput(t *testing.T) {
+	buildBinary(t)
+
+	// Test writing the IP list to stdout
+	for _, o := range []string{"-", "/dev/stdout"} {
+		output, err := runCommand(binPath, "-o", o, "10.0.0.0/30")
+		if err != nil {
+			t.Fatalf("Command failed with error: %v", err)
+		}
+
+		expected := "10.0.0.0\n10.0.0.1\n10.0.0.2\n10.0.0.3\n"
+		if output != expected {
+			t.Errorf("Expected %q, got %q instead.", expected, output)
+		}
+	}
+
+	removeFiles(t)
+}
+
+func TestOutputFile(t *testing.T) {
+	buildBinary(t)
+
+	// Test writing the IP list to a named file
+	file := "output.csv"
+	output, err := runCommand(binPath, "-o", file, "10.0.0.0/30")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		t.Fatalf("Failed to resolve path: %v", err)
+	}
+	if saved := extractFileName(output, "IP list saved to (.+\\.csv)"); saved != abs {
+		t.Errorf("Expected output saved to '%s', got '%s' instead.", abs, saved)
+	}
+
+	// Test that an existing file is not overwritten without -force
+	checkError(t, binPath, "-o", file, "10.0.0.0/24")
+
+	// Test overwriting an existing file with -force
+	if _, err := runCommand(binPath, "-o", file, "-force", "10.0.0.0/24"); err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Error reading file: %v", err)
+	}
+	if count := strings.Count(string(data), "\n"); count != 256 {
+		t.Errorf("Expected 256 IP addresses, but found %d", count)
+	}
+
+	removeFiles(t, file)
+}
+
+func TestOutdir(t *testing.T) {
+	buildBinary(t)
+
+	// Test that the timestamped file lands in -outdir, which is created
+	dir := filepath.Join(t.TempDir(), "lists", "new")
+	output, err := runCommand(binPath, "-outdir", dir, "10.0.0.0/31")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	file := extractFileName(output, "IP list saved to (.+\\.csv)")
+	if filepath.Dir(file) != dir || !strings.HasPrefix(filepath.Base(file), "cidr2ip_") {
+		t.Errorf("Expected a timestamped file in %s, got %s instead.", dir, file)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Error reading file: %v", err)
+	}
+	if expected := "10.0.0.0\n10.0.0.1\n"; string(data) != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, data)
+	}
+
+	// Test that an explicit -o wins
+	explicit := "outdir.csv"
+	if _, err := runCommand(binPath, "-outdir", dir, "-o", explicit, "10.0.0.0/31"); err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if _, err := os.Stat(explicit); err != nil {
+		t.Errorf("Expected %s in the current directory: %v", explicit, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, explicit)); !os.IsNotExist(err) {
+		t.Errorf("Expected no %s in %s, got %v instead.", explicit, dir, err)
+	}
+
+	removeFiles(t, explicit)
+}
+
+func TestAppend(t *testing.T) {
+	buildBinary(t)
+
+	// Test that a new file gets the header and later runs only add rows
+	file := "append.csv"
+	for _, cidr := range []string{"10.0.0.0/31", "192.168.0.0/31"} {
+		if _, err := runCommand(binPath, "-append", "-header", "-o", file, cidr); err != nil {
+			t.Fatalf("Command failed with error: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Error reading file: %v", err)
+	}
+
+	expected := "ip\n10.0.0.0\n10.0.0.1\n192.168.0.0\n192.168.0.1\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, string(data))
+	}
+
+	// Test the combinations -append refuses
+	checkError(t, binPath, "-append", "10.0.0.0/31")
+	checkError(t, binPath, "-append", "-force", "-o", file, "10.0.0.0/31")
+	checkError(t, binPath, "-append", "-format", "json", "-o", file, "10.0.0.0/31")
+
+	removeFiles(t, file)
+}
+
+func TestNoClobber(t *testing.T) {
+	buildBinary(t)
+
+	// Test that rapid runs, likely in the same second, never overwrite each other
+	cidrs := []string{"10.0.0.0/31", "10.0.1.0/31", "10.0.2.0/31"}
+	var files []string
+	for _, cidr := range cidrs {
+		output, err := runCommand(binPath, cidr)
+		if err != nil {
+			t.Fatalf("Command failed with error: %v", err)
+		}
+		files = append(files, extractFileName(output, "IP list saved to (.+\\.csv)"))
+	}
+
+	for i, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Error reading file: %v", err)
+		}
+		if !strings.HasPrefix(string(data), strings.TrimSuffix(cidrs[i], "/31")+"\n") {
+			t.Errorf("Expected %s to hold the IPs of %s, got %q instead.", file, cidrs[i], string(data))
+		}
+	}
+
+	removeFiles(t, files...)
+}
+
+// failingWriter fails every write, like a full disk.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("no space left on device")
+}
+
+func TestFlushErrors(t *testing.T) {
+	// Test that every format reports a write that only fails when flushed
+	tmpl, err := parseTemplate("{{.IP}}")
+	if err != nil {
+		t.Fatalf("parseTemplate failed with error: %v", err)
+	}
+	for name, newWriter := range formats {
+		w := newWriter(failingWriter{}, []string{"ip"}, &options{tmpl: tmpl})
+		if err := w.WriteRow([]string{"10.0.0.0"}); err != nil {
+			continue
+		}
+		if err := w.Flush(); err == nil {
+			t.Errorf("%s: expected an error from Flush, got nil instead.", name)
+		}
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("/dev/full is not available")
+	}
+	buildBinary(t)
+
+	// Test that a full disk fails the run instead of reporting success
+	for _, name := range []string{"csv", "txt"} {
+		output, err := runCommand(binPath, "-format", name, "-force", "-o", "/dev/full", "10.0.0.0/24")
+		if err == nil {
+			t.Errorf("%s: expected an error, but command succeeded.", name)
+		}
+		if strings.Contains(output, "IP list saved") {
+			t.Errorf("%s: expected no success message, got %q instead.", name, output)
+		}
+	}
+
+	removeFiles(t)
+}
+
+func TestAtomicOutput(t *testing.T) {
+	buildBinary(t)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "ips.parquet")
+	listDir := func() []string {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("Error reading directory: %v", err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return names
+	}
+
+	// Test that a run failing mid-write, here on the first IPv6 address the int
+	// column can't hold, leaves neither the file nor its temporary behind
+	if _, err := runCommand(binPath, "-format", "parquet", "-int", "-o", file, "10.0.0.0/16", "2001:db8::/127"); err == nil {
+		t.Fatal("Expected an error, but command succeeded.")
+	}
+	if names := listDir(); len(names) > 0 {
+		t.Errorf("Expected an empty directory, got %q instead.", names)
+	}
+
+	// Test that a failed -force run keeps the file it would have replaced
+	if err := os.WriteFile(file, []byte("previous"), 0644); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	if _, err := runCommand(binPath, "-format", "parquet", "-int", "-force", "-o", file, "10.0.0.0/16", "2001:db8::/127"); err == nil {
+		t.Fatal("Expected an error, but command succeeded.")
+	}
+	if data, err := os.ReadFile(file); err != nil || string(data) != "previous" {
+		t.Errorf("Expected the previous file to be kept, got %q (%v) instead.", data, err)
+	}
+
+	// Test that a successful run leaves only the final file
+	if _, err := runCommand(binPath, "-format", "parquet", "-int", "-force", "-o", file, "10.0.0.0/16"); err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if names := listDir(); !slices.Equal(names, []string{"ips.parquet"}) {
+		t.Errorf("Expected only ips.parquet, got %q instead.", names)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("Expected a 0644 file, got %v (%v) instead.", info, err)
+	}
+
+	// Test that a replaced file keeps its permissions, and that a new one gets
+	// those the umask leaves, as with os.Create
+	if err := os.Chmod(file, 0640); err != nil {
+		t.Fatalf("Failed to change mode: %v", err)
+	}
+	if _, err := runCommand(binPath, "-format", "parquet", "-force", "-o", file, "10.0.0.0/31"); err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("Expected a 0640 file, got %v (%v) instead.", info, err)
+	}
+	if runtime.GOOS != "windows" {
+		private := filepath.Join(dir, "private.csv")
+		if output, err := exec.Command("sh", "-c", `umask 077 && exec "$0" -o "$1" 10.0.0.0/31`, binPath, private).CombinedOutput(); err != nil {
+			t.Fatalf("Command failed with error: %v: %s", err, output)
+		}
+		if info, err := os.Stat(private); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("Expected a 0600 file under umask 077, got %v (%v) instead.", info, err)
+		}
+		os.Remove(private)
+	}
+
+	// Test that a missing directory is reported with the file asked for
+	missing := filepath.Join(dir, "nodir", "ips.csv")
+	output, err := runCommand(binPath, "-o", missing, "10.0.0.0/31")
+	if err == nil || !strings.Contains(output, missing+": no such file or directory") || strings.Contains(output, ".tmp") {
+		t.Errorf("Expected an error naming %s, got %q (%v) instead.", missing, output, err)
+	}
+
+	// Test that devices are written through without -force and left in place
+	if _, err := runCommand(binPath, "-o", os.DevNull, "10.0.0.0/31"); err != nil {
+		t.Errorf("Expected %s to be written without -force, got %v instead.", os.DevNull, err)
+	}
+	if info, err := os.Stat(os.DevNull); err != nil || info.Mode().IsRegular() {
+		t.Errorf("Expected %s to stay a device, got %v (%v) instead.", os.DevNull, info, err)
+	}
+
+	// Test that -force writes through a symlink instead of replacing it
+	target, link := filepath.Join(dir, "target.csv"), filepath.Join(dir, "link.csv")
+	if err := os.WriteFile(target, []byte("old\n"), 0644); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if _, err := runCommand(binPath, "-o", link, "10.0.0.0/31"); err == nil {
+		t.Error("Expected an existing symlink to need -force, but command succeeded.")
+	}
+	if _, err := runCommand(binPath, "-force", "-o", link, "10.0.0.0/31"); err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Expected %s to stay a symlink, got %v (%v) instead.", link, info, err)
+	}
+	if data, err := os.ReadFile(target); err != nil || string(data) != "10.0.0.0\n10.0.0.1\n" {
+		t.Errorf("Expected the IPs in %s, got %q (%v) instead.", target, data, err)
+	}
+	removeFiles(t)
+}
+
+func TestSplitFiles(t *testing.T) {
+	buildBinary(t)
+
+	// Test that each CIDR gets its own file in the output directory
+	dir := "split_files"
+	output, err := runCommand(binPath, "-split-files", "-outdir", dir, "10.0.0.0/30", "192.168.1.0/31", "2001:db8::/127")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	expected := map[string]string{
+		"10.0.0.0_30.csv":    "10.0.0.0\n10.0.0.1\n10.0.0.2\n10.0.0.3\n",
+		"192.168.1.0_31.csv": "192.168.1.0\n192.168.1.1\n",
+		"2001-db8--_127.csv": "2001:db8::\n2001:db8::1\n",
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Error reading directory: %v", err)
+	}
+	if len(entries) != len(expected) {
+		t.Errorf("Expected %d files, got %d instead.", len(expected), len(entries))
+	}
+
+	for name, content := range expected {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("Error reading file: %v", err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("Expected %q in %s, got %q instead.", content, name, string(data))
+		}
+
+		// Test that the final message lists every file
+		if !strings.Contains(output, name) {
+			t.Errorf("Expected %s in output, got %q instead.", name, output)
+		}
+	}
+
+	checkError(t, binPath, "-split-files", "-o", "out.csv", "10.0.0.0/30")
+
+	removeFiles(t)
+}
+
+func TestSplitFilesParallel(t *testing.T) {
+	buildBinary(t)
+
+	// Test that many small CIDRs written 4 at a time all come out right
+	dir := t.TempDir()
+	var cidrs []string
+	for i := 0; i < 64; i++ {
+		cidrs = append(cidrs, fmt.Sprintf("10.0.%d.0/29", i))
+	}
+	output, err := runCommand(binPath, append([]string{"-j", "4", "-split-files", "-outdir", dir, "-stats"}, cidrs...)...)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v: %s", err, output)
+	}
+	if !regexp.MustCompile(`IPs generated:\s+512\n`).MatchString(output) {
+		t.Errorf("Expected 512 IPs in the stats, got %q instead.", output)
+	}
+
+	for i, cidr := range cidrs {
+		name := splitFileName(cidr) + ".csv"
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("Error reading file: %v", err)
+			continue
+		}
+
+		var expected strings.Builder
+		for j := 0; j < 8; j++ {
+			fmt.Fprintf(&expected, "10.0.%d.%d\n", i, j)
+		}
+		if string(data) != expected.String() {
+			t.Errorf("Expected %q in %s, got %q instead.", expected.String(), name, string(data))
+		}
+	}
+
+	// Test that the files are still listed in input order
+	if a, b := strings.Index(output, "10.0.1.0_29.csv"), strings.Index(output, "10.0.63.0_29.csv"); a < 0 || b < a {
+		t.Errorf("Expected the files in input order, got %q instead.", output)
+	}
+
+	// Test that a writer failing makes the run fail while the others finish
+	if err := os.Mkdir(filepath.Join(dir, "10.0.65.0_29.csv"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	output, err = runCommand(binPath, "-j", "4", "-force", "-split-files", "-outdir", dir, "10.0.64.0/29", "10.0.65.0/29", "10.0.66.0/29")
+	if err == nil {
+		t.Errorf("Expected an error, but command succeeded: %s", output)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "10.0.66.0_29.csv")); err != nil {
+		t.Errorf("Expected the other files to be written: %v", err)
+	}
+
+	// Test that writing files concurrently only hides the progress, not warnings
+	output, err = runCommand(binPath, "-j", "4", "-sample", "5", "-split-files", "-outdir", t.TempDir(), "10.0.70.0/31", "10.0.71.0/31")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v: %s", err, output)
+	}
+	if n := strings.Count(output, "Warning: -sample 5 exceeds"); n != 2 {
+		t.Errorf("Expected 2 -sample warnings, got %q instead.", output)
+	}
+
+	removeFiles(t)
+}
+
+func TestInterrupt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("interrupts can't be sent to a process on Windows")
+	}
+	buildBinary(t)
+
+	// Test that an interrupted run leaves a file of complete lines behind
+	file := "interrupted.csv"
+	var stderr bytes.Buffer
+	cmd := exec.Command(binPath, "-o", file, "10.0.0.0/8")
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start command: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		t.Fatalf("Failed to interrupt command: %v", err)
+	}
+
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 130 {
+		t.Fatalf("Expected exit code 130, got %v instead.", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Error reading file: %v", err)
+	}
+	count := strings.Count(string(data), "\n")
+	if count == 0 || !strings.HasSuffix(string(data), "\n") {
+		t.Errorf("Expected complete lines, got %d bytes instead.", len(data))
+	}
+
+	// Test that the number of IPs written is reported
+	expected := fmt.Sprintf("interrupted after writing %d IPs", count)
+	if !strings.Contains(stderr.String(), expected) {
+		t.Errorf("Expected %q in output, got %q instead.", expected, stderr.String())
+	}
+
+	removeFiles(t, file)
+}
+
+func TestOutputOrder(t *testing.T) {
+	buildBinary(t)
+
+	// Test that blocks appear in the same order as the input CIDRs
+	output, err := runCommand(binPath, "-o", "-", "192.168.0.0/31", "10.0.0.0/31", "172.16.0.0/31")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "192.168.0.0\n192.168.0.1\n10.0.0.0\n10.0.0.1\n172.16.0.0\n172.16.0.1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	removeFiles(t)
+}
+
+func TestJobs(t *testing.T) {
+	buildBinary(t)
+
+	// Test that the worker count doesn't change the output, including when
+	// CIDRs span several batches and when -limit stops the expansion early
+	cidrs := []string{"10.0.0.0/20", "192.168.0.0/31", "2001:db8::/116", "172.16.0.0/22", "10.0.0.0/30"}
+	for _, extra := range [][]string{nil, {"-with-cidr"}, {"-limit", "5000"}} {
+		var outputs []string
+		for _, j := range []string{"1", "4"} {
+			args := append(append([]string{"-j", j, "-o", "-"}, extra...), cidrs...)
+			output, err := runCommand(binPath, args...)
+			if err != nil {
+				t.Fatalf("Command failed with error: %v", err)
+			}
+			outputs = append(outputs, output)
+		}
+
+		if outputs[0] != outputs[1] {
+			t.Errorf("Expected the same output with -j 1 and -j 4 for %v.", extra)
+		}
+	}
+
+	checkError(t, binPath, "-j", "0", "10.0.0.0/30")
+
+	removeFiles(t)
+}
+
+func TestStats(t *testing.T) {
+	buildBinary(t)
+
+	// Test the summary of a run with overlapping CIDRs
+	file := "stats.csv"
+	output, err := runCommand(binPath, "-stats", "-o", file, "10.0.0.0/30", "10.0.0.2/31", "192.168.0.0/31")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	for _, pattern := range []string{`CIDRs processed:\s+3\n`, `IPs generated:\s+8\n`, `Duplicate IPs:\s+2\n`, `Elapsed time:\s+\S+\n`} {
+		if !regexp.MustCompile(pattern).MatchString(output) {
+			t.Errorf("Expected output to match %q, got %q instead.", pattern, output)
+		}
+	}
+
+	// Test that -dedup leaves no duplicates to report
+	output, err = runCommand(binPath, "-stats", "-dedup", "-force", "-o", file, "10.0.0.0/30", "10.0.0.2/31")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	for _, pattern := range []string{`IPs generated:\s+4\n`, `Duplicate IPs:\s+0\n`} {
+		if !regexp.MustCompile(pattern).MatchString(output) {
+			t.Errorf("Expected output to match %q, got %q instead.", pattern, output)
+		}
+	}
+
+	removeFiles(t, file)
+}
+
+func TestJSONSummary(t *testing.T) {
+	buildBinary(t)
+
+	// Test the report of a run, written to a file next to the human message
+	file, report := "summary.csv", "summary.json"
+	output, err := runCommand(binPath, "-json-summary", report, "-keep-going", "-o", file, "10.0.0.0/30", "10.0.0.0/33", "192.168.0.0/31")
+	if err == nil {
+		t.Error("Expected an error, but command succeeded.")
+	}
+	if !strings.Contains(output, "IP list saved to") {
+		t.Errorf("Expected the human message too, got %q instead.", output)
+	}
+
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatalf("Failed to read the report: %v", err)
+	}
+	var summary struct {
+		Inputs   int      `json:"inputs"`
+		CIDRs    int      `json:"cidrs"`
+		IPs      uint64   `json:"ips"`
+		Outputs  []string `json:"outputs"`
+		Duration float64  `json:"duration_seconds"`
+		Errors   []string `json:"errors"`
+	}
+	if err := json.Unmarshal(data, &summary); err != nil {
+		t.Fatalf("Failed to parse the report %q: %v", data, err)
+	}
+
+	abs, _ := filepath.Abs(file)
+	if summary.Inputs != 3 || summary.CIDRs != 2 || summary.IPs != 6 || summary.Duration <= 0 {
+		t.Errorf("Expected 3 inputs, 2 CIDRs and 6 IPs, got %+v instead.", summary)
+	}
+	if len(summary.Outputs) != 1 || summary.Outputs[0] != abs {
+		t.Errorf("Expected the output %s, got %v instead.", abs, summary.Outputs)
+	}
+	if len(summary.Errors) != 1 || !strings.Contains(summary.Errors[0], "10.0.0.0/33") {
+		t.Errorf("Expected the invalid CIDR among the errors, got %v instead.", summary.Errors)
+	}
+
+	// Test writing it to stderr, which keeps stdout for the IPs
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(binPath, "-json-summary", "-", "-o", "-", "10.0.0.0/31")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if expected := "10.0.0.0\n10.0.0.1\n"; stdout.String() != expected {
+		t.Errorf("Expected %q on stdout, got %q instead.", expected, stdout.String())
+	}
+	if err := json.Unmarshal(stderr.Bytes(), &summary); err != nil {
+		t.Fatalf("Failed to parse the report %q: %v", stderr.String(), err)
+	}
+	if summary.IPs != 2 || len(summary.Errors) != 0 || len(summary.Outputs) != 1 || summary.Outputs[0] != "stdout" {
+		t.Errorf("Expected 2 IPs to stdout and no errors, got %+v instead.", summary)
+	}
+
+	// Test that a run refused by -max-ips still reports why
+	stderr.Reset()
+	cmd = exec.Command(binPath, "-json-summary", "-", "-max-ips", "10", "-o", "-", "10.0.0.0/24")
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitUsage {
+		t.Errorf("Expected exit code %d, got %v instead.", exitUsage, err)
+	}
+	if err := json.NewDecoder(&stderr).Decode(&summary); err != nil {
+		t.Fatalf("Failed to parse the report %q: %v", stderr.String(), err)
+	}
+	if len(summary.Errors) != 1 || !strings.Contains(summary.Errors[0], "-max-ips 10") || len(summary.Outputs) != 0 {
+		t.Errorf("Expected the -max-ips error and no outputs, got %+v instead.", summary)
+	}
+
+	removeFiles(t, file, report)
+}
+
+func TestSort(t *testing.T) {
+	buildBinary(t)
+
+	// Test that IPs are sorted numerically rather than as strings
+	output, err := runCommand(binPath, "-sort", "-o", "-", "10.0.0.10/31", "2001:db8::/127", "10.0.0.8/31", "10.0.0.9-10.0.0.10")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "10.0.0.8\n10.0.0.9\n10.0.0.9\n10.0.0.10\n10.0.0.10\n10.0.0.11\n2001:db8::\n2001:db8::1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that the source column follows its IP
+	output, err = runCommand(binPath, "-sort", "-with-cidr", "-o", "-", "10.0.0.2/31", "10.0.0.0/31")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected = "10.0.0.0,10.0.0.0/31\n10.0.0.1,10.0.0.0/31\n10.0.0.2,10.0.0.2/31\n10.0.0.3,10.0.0.2/31\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	removeFiles(t)
+}
+
+func TestShuffle(t *testing.T) {
+	buildBinary(t)
+
+	args := []string{"-shuffle", "-seed", "42", "-o", "-", "10.0.0.0/28", "10.0.1.0/28"}
+	output, err := runCommand(binPath, args...)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	shuffled := strings.Split(strings.TrimSpace(output), "\n")
+
+	ordered, err := runCommand(binPath, "-o", "-", "10.0.0.0/28", "10.0.1.0/28")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	expected := strings.Split(strings.TrimSpace(ordered), "\n")
+
+	// Test that the same IPs come out in a different order
+	if slices.Equal(shuffled, expected) {
+		t.Errorf("Expected a shuffled order, got %v instead.", shuffled)
+	}
+	sorted := slices.Clone(shuffled)
+	slices.SortFunc(sorted, func(a, b string) int {
+		return netip.MustParseAddr(a).Compare(netip.MustParseAddr(b))
+	})
+	if !slices.Equal(sorted, expected) {
+		t.Errorf("Expected the IPs of %v, got %v instead.", expected, shuffled)
+	}
+
+	// Test that the same seed gives the same order
+	again, err := runCommand(binPath, args...)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if again != output {
+		t.Errorf("Expected %q with the same seed, got %q instead.", output, again)
+	}
+
+	checkError(t, binPath, "-shuffle", "-sort", "10.0.0.0/28")
+
+	removeFiles(t)
+}
+
+func TestBoundaries(t *testing.T) {
+	buildBinary(t)
+
+	// Test that only the addresses present are written for /31 and /32
+	output, err := runCommand(binPath, "-boundaries", "-o", "-", "192.168.1.0/24", "10.0.0.0/31", "10.0.0.7/32", "2001:db8::/32")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "192.168.1.0\n192.168.1.255\n10.0.0.0\n10.0.0.1\n10.0.0.7\n2001:db8::\n2001:db8:ffff:ffff:ffff:ffff:ffff:ffff\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	removeFiles(t)
+}
+
+func TestEndpoints(t *testing.T) {
+	buildBinary(t)
+
+	// Test that -endpoints gives the network and broadcast, as -boundaries does
+	cidrs := []string{"192.168.1.0/24", "10.0.0.4/30", "10.0.0.0/31", "10.0.0.7/32", "2001:db8::/126"}
+	output, err := runCommand(binPath, append([]string{"-endpoints", "-o", "-"}, cidrs...)...)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	expected := "192.168.1.0\n192.168.1.255\n10.0.0.4\n10.0.0.7\n10.0.0.0\n10.0.0.1\n10.0.0.7\n2001:db8::\n2001:db8::3\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that -hosts gives the usable range, whole for /31, /32 and IPv6
+	output, err = runCommand(binPath, append([]string{"-endpoints", "-hosts", "-o", "-"}, cidrs...)...)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	expected = "192.168.1.1\n192.168.1.254\n10.0.0.5\n10.0.0.6\n10.0.0.0\n10.0.0.1\n10.0.0.7\n2001:db8::\n2001:db8::3\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	checkError(t, binPath, "-hosts", "10.0.0.0/30")
+
+	removeFiles(t)
+}
+
+func TestOnlyNetwork(t *testing.T) {
+	buildBinary(t)
+
+	// Test that each CIDR gives one row, its masked network address
+	output, err := runCommand(binPath, "-only-network", "-with-cidr", "-o", "-", "10.0.0.5/24", "172.16.33.9/20", "10.0.0.7/32", "2001:db8::1/32", "10.1.0.3-10.1.0.9")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	expected := "10.0.0.0,10.0.0.5/24\n172.16.32.0,172.16.33.9/20\n10.0.0.7,10.0.0.7/32\n2001:db8::,2001:db8::1/32\n10.1.0.3,10.1.0.3-10.1.0.9\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that -dry-run counts one IP per CIDR, however large
+	output, err = runCommand(binPath, "-only-network", "-dry-run", "-o", "-", "10.0.0.0/8", "2001:db8::/32")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if !strings.Contains(output, "2 IPs") {
+		t.Errorf("Expected 2 IPs, got %q instead.", output)
+	}
+
+	checkError(t, binPath, "-only-network", "-boundaries", "10.0.0.0/24")
+
+	removeFiles(t)
+}
+
+func TestResolve(t *testing.T) {
+	buildBinary(t)
+
+	// Test that each IP gets its PTR name, resolved here from the hosts file
+	output, err := runCommand(binPath, "-resolve", "-o", "-", "127.0.0.1/32")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "127.0.0.1,localhost\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that the PTR column is part of each JSON object
+	output, err = runCommand(binPath, "-resolve", "-format", "json", "-o", "-", "127.0.0.1/32")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected = `[{"ip":"127.0.0.1","ptr":"localhost"}]` + "\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	removeFiles(t)
+}
+
+func TestLimit(t *testing.T) {
+	buildBinary(t)
+
+	// Test that the limit applies across CIDRs in input order
+	output, err := runCommand(binPath, "-limit", "3", "-o", "-", "10.0.0.0/31", "10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "10.0.0.0\n10.0.0.1\n10.0.0.0\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test with a limit larger than the number of IPs
+	output, err = runCommand(binPath, "-limit", "100", "-o", "-", "10.0.0.0/31")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected = "10.0.0.0\n10.0.0.1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that a filter doesn't keep the walk going once the limit is reached
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, binPath, "-limit", "1", "-match", `^0\.0\.0\.5$`, "-max-ips", "0", "-o", "-", "0.0.0.0/0").CombinedOutput()
+	if err != nil {
+		t.Fatalf("Command failed with error: %v: %s", err, out)
+	}
+	if expected := "0.0.0.5\n"; string(out) != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, out)
+	}
+
+	// Test with a negative limit
+	checkError(t, binPath, "-limit", "-1", "10.0.0.0/31")
+
+	removeFiles(t)
+}
+
+func TestTail(t *testing.T) {
+	buildBinary(t)
+
+	// Test taking the high end of a block, and all of a smaller one
+	output, err := runCommand(binPath, "-tail", "3", "-o", "-", "192.168.1.0/24", "10.0.0.0/31")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "192.168.1.253\n192.168.1.254\n192.168.1.255\n10.0.0.0\n10.0.0.1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that a huge IPv6 block is fine, as nothing before the tail is expanded
+	output, err = runCommand(binPath, "-tail", "2", "-o", "-", "2001:db8::/32")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected = "2001:db8:ffff:ffff:ffff:ffff:ffff:fffe\n2001:db8:ffff:ffff:ffff:ffff:ffff:ffff\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that it can't be combined with another selection
+	checkError(t, binPath, "-tail", "3", "-sample", "3", "10.0.0.0/24")
+	checkError(t, binPath, "-tail", "-1", "10.0.0.0/24")
+
+	removeFiles(t)
+}
+
+func TestEvery(t *testing.T) {
+	buildBinary(t)
+
+	// Test spreading IPs evenly over a /24, and that the count agrees
+	output, err := runCommand(binPath, "-every", "64", "-o", "-", "192.168.1.0/24")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "192.168.1.0\n192.168.1.64\n192.168.1.128\n192.168.1.192\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	output, err = runCommand(binPath, "-every", "64", "-dry-run", "-o", "-", "192.168.1.0/24", "10.0.0.0/30")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if !strings.Contains(output, "Would write 5 IPs") {
+		t.Errorf("Expected 5 IPs, got %q instead.", output)
+	}
+
+	// Test that the stride stops at the top of the address space
+	output, err = runCommand(binPath, "-every", "3", "-o", "-", "255.255.255.250-255.255.255.255")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected = "255.255.255.250\n255.255.255.253\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	checkError(t, binPath, "-every", "-1", "10.0.0.0/24")
+	checkError(t, binPath, "-every", "2", "-tail", "2", "10.0.0.0/24")
+
+	removeFiles(t)
+}
+
+func TestSample(t *testing.T) {
+	buildBinary(t)
+
+	// Test that K distinct IPs are picked from each CIDR
+	output, err := runCommand(binPath, "-sample", "5", "-seed", "42", "-o", "-", "10.0.0.0/24", "192.168.0.0/16")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	ips := strings.Fields(output)
+	if len(ips) != 10 {
+		t.Fatalf("Expected 10 sampled IPs, got %d instead: %v", len(ips), ips)
+	}
+
+	seen := map[string]bool{}
+	for i, ip := range ips {
+		prefix := "10.0.0."
+		if i >= 5 {
+			prefix = "192.168."
+		}
+		if !strings.HasPrefix(ip, prefix) {
+			t.Errorf("Expected %s to belong to the block starting with %s", ip, prefix)
+		}
+		if seen[ip] {
+			t.Errorf("Expected distinct IPs, but %s was picked twice", ip)
+		}
+		seen[ip] = true
+	}
+
+	// Test that the same seed gives the same sample
+	again, err := runCommand(binPath, "-sample", "5", "-seed", "42", "-o", "-", "10.0.0.0/24", "192.168.0.0/16")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if again != output {
+		t.Errorf("Expected the same sample for the same seed, got %q and %q", output, again)
+	}
+
+	// Test with K larger than the block
+	output, err = runCommand(binPath, "-sample", "8", "-o", "-", "10.0.0.0/30")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if !strings.Contains(output, "Warning:") || !strings.HasSuffix(output, "\n10.0.0.0\n10.0.0.1\n10.0.0.2\n10.0.0.3\n") {
+		t.Errorf("Expected a warning and all 4 IPs, got %q instead.", output)
+	}
+
+	// Test that -q leaves the warning out
+	output, err = runCommand(binPath, "-q", "-sample", "8", "-o", "-", "10.0.0.0/30")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if expected := "10.0.0.0\n10.0.0.1\n10.0.0.2\n10.0.0.3\n"; output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	removeFiles(t)
+}
+
+func TestDedup(t *testing.T) {
+	buildBinary(t)
+
+	args := []string{"-o", "-", "10.0.0.0/30", "10.0.0.0/31", "10.0.0.2-10.0.0.5", "192.168.0.0/31"}
+
+	// Test that overlapping CIDRs produce duplicates by default
+	output, err := runCommand(binPath, args...)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if count := strings.Count(output, "\n"); count != 12 {
+		t.Errorf("Expected 12 IP addresses, but found %d", count)
+	}
+
+	// Test that -dedup writes each IP once, keeping the input order
+	output, err = runCommand(binPath, append([]string{"-dedup"}, args...)...)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "10.0.0.0\n10.0.0.1\n10.0.0.2\n10.0.0.3\n10.0.0.4\n10.0.0.5\n192.168.0.0\n192.168.0.1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	removeFiles(t)
+}
+
+func TestWarnOverlap(t *testing.T) {
+	buildBinary(t)
+
+	// Test that overlapping pairs are reported and the IPs still written
+	file := "overlap.csv"
+	output, err := runCommand(binPath, "-warn-overlap", "-o", file, "10.0.0.0/24", "10.0.0.0/25", "192.168.0.0/31")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "Warning: 10.0.0.0/24 overlaps 10.0.0.0/25\n"
+	if !strings.HasPrefix(output, expected) {
+		t.Errorf("Expected output to start with %q, got %q instead.", expected, output)
+	}
+	if strings.Count(output, "Warning:") != 1 {
+		t.Errorf("Expected a single warning, got %q instead.", output)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Error reading file: %v", err)
+	}
+	if count := strings.Count(string(data), "\n"); count != 256+128+2 {
+		t.Errorf("Expected %d IP addresses, but found %d", 256+128+2, count)
+	}
+
+	removeFiles(t, file)
+}
+
+func TestMerge(t *testing.T) {
+	buildBinary(t)
+
+	// Test that overlapping and adjacent CIDRs are merged, then expanded in order
+	output, err := runCommand(binPath, "-merge", "-o", "-", "10.0.0.2/31", "192.168.0.0/31", "10.0.0.0/31", "10.0.0.1/32")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "10.0.0.0\n10.0.0.1\n10.0.0.2\n10.0.0.3\n192.168.0.0\n192.168.0.1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	removeFiles(t)
+}
+
+func TestIntColumn(t *testing.T) {
+	buildBinary(t)
+
+	// Test that each IP is followed by its integer form
+	output, err := runCommand(binPath, "-int", "-o", "-", "10.0.0.0/31")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "10.0.0.0,167772160\n10.0.0.1,167772161\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	removeFiles(t)
+}
+
+func TestDelimiter(t *testing.T) {
+	buildBinary(t)
+
+	// Test custom CSV field separators, including the \t token
+	tests := []struct {
+		delimiter string
+		expected  string
+	}{
+		{";", "10.0.0.0;167772160\n10.0.0.1;167772161\n"},
+		{`\t`, "10.0.0.0\t167772160\n10.0.0.1\t167772161\n"},
+		{"|", "10.0.0.0|167772160\n10.0.0.1|167772161\n"},
+	}
+
+	for _, test := range tests {
+		output, err := runCommand(binPath, "-delimiter", test.delimiter, "-int", "-o", "-", "10.0.0.0/31")
+		if err != nil {
+			t.Fatalf("Command failed with error: %v", err)
+		}
+		if output != test.expected {
+			t.Errorf("Expected %q for delimiter %q, got %q instead.", test.expected, test.delimiter, output)
+		}
+	}
+
+	// Test that anything but a single usable character is rejected
+	for _, delimiter := range []string{"", ";;", `"`, "\n"} {
+		checkError(t, binPath, "-delimiter", delimiter, "-o", "-", "10.0.0.0/31")
+	}
+
+	removeFiles(t)
+}
+
+func TestHeader(t *testing.T) {
+	buildBinary(t)
+
+	// Test that the header names exactly the active columns
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{nil, "10.0.0.0\n10.0.0.1\n"},
+		{[]string{"-header"}, "ip\n10.0.0.0\n10.0.0.1\n"},
+		{[]string{"-header", "-int"}, "ip,int\n10.0.0.0,167772160\n10.0.0.1,167772161\n"},
+		{[]string{"-header", "-int", "-delimiter", ";"}, "ip;int\n10.0.0.0;167772160\n10.0.0.1;167772161\n"},
+	}
+
+	for _, test := range tests {
+		args := append(test.args, "-o", "-", "10.0.0.0/31")
+		output, err := runCommand(binPath, args...)
+		if err != nil {
+			t.Fatalf("Command failed with error: %v", err)
+		}
+		if output != test.expected {
+			t.Errorf("Expected %q for %v, got %q instead.", test.expected, test.args, output)
+		}
+	}
+
+	removeFiles(t)
+}
+
+func TestWithCIDR(t *testing.T) {
+	buildBinary(t)
+
+	// Test that every row names the block its IP falls in
+	output, err := runCommand(binPath, "-with-cidr", "-o", "-", "10.0.0.0/30", "192.168.1.0/31")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("Expected 6 rows, got %d instead: %q", len(lines), output)
+	}
+	for _, line := range lines {
+		ip, cidr, ok := strings.Cut(line, ",")
+		if !ok {
+			t.Fatalf("Expected 2 columns, got %q instead.", line)
+		}
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("Invalid CIDR column %q: %v", cidr, err)
+		}
+		if !ipnet.Contains(net.ParseIP(ip)) {
+			t.Errorf("Expected %s to fall in %s.", ip, cidr)
+		}
+	}
+
+	// Test that -dedup attributes shared IPs to the first block covering them
+	output, err = runCommand(binPath, "-with-cidr", "-dedup", "-o", "-", "10.0.0.0/31", "10.0.0.0/30")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "10.0.0.0,10.0.0.0/31\n10.0.0.1,10.0.0.0/31\n10.0.0.2,10.0.0.0/30\n10.0.0.3,10.0.0.0/30\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	removeFiles(t)
+}
+
+func TestColumns(t *testing.T) {
+	buildBinary(t)
+
+	// Test that the columns come in the order listed, the same in every format
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{"csv", "int,ip,cidr\n167772160,10.0.0.0,10.0.0.0/31\n167772161,10.0.0.1,10.0.0.0/31\n"},
+		{"ndjson", `{"int":"167772160","ip":"10.0.0.0","cidr":"10.0.0.0/31"}` + "\n" + `{"int":"167772161","ip":"10.0.0.1","cidr":"10.0.0.0/31"}` + "\n"},
+		{"hosts", "10.0.0.0 host-10-0-0-0\n10.0.0.1 host-10-0-0-1\n"},
+	}
+	for _, tt := range tests {
+		output, err := runCommand(binPath, "-columns", "int, ip,cidr", "-header", "-format", tt.format, "-o", "-", "10.0.0.0/31")
+		if err != nil {
+			t.Fatalf("Command failed with error: %v", err)
+		}
+		if output != tt.expected {
+			t.Errorf("%s: expected %q, got %q instead.", tt.format, tt.expected, output)
+		}
+	}
+
+	// Test the hex column through the CLI
+	output, err := runCommand(binPath, "-columns", "ip,hex", "-o", "-", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if expected := "10.0.0.1,0A000001\n"; output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test the mapped column through the CLI, with a header and as JSON
+	output, err = runCommand(binPath, "-columns", "ip,mapped", "-header", "-o", "-", "10.0.0.1", "192.168.1.254", "2001:db8::1")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if expected := "ip,mapped\n10.0.0.1,::ffff:10.0.0.1\n192.168.1.254,::ffff:192.168.1.254\n2001:db8::1,2001:db8::1\n"; output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+	output, err = runCommand(binPath, "-columns", "ip,mapped", "-format", "ndjson", "-o", "-", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if expected := `{"ip":"10.0.0.1","mapped":"::ffff:10.0.0.1"}` + "\n"; output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test the class column through the CLI
+	output, err = runCommand(binPath, "-columns", "ip,class", "-o", "-", "127.0.0.1", "8.8.8.8")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if expected := "127.0.0.1,loopback\n8.8.8.8,global\n"; output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test the prefix column of IPs from a /20 and a /28
+	output, err = runCommand(binPath, "-columns", "ip,prefix", "-limit", "1", "-o", "-", "10.0.0.0/20")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if expected := "10.0.0.0,20/32\n"; output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+	output, err = runCommand(binPath, "-columns", "ip,prefix", "-boundaries", "-o", "-", "10.0.0.5/28", "2001:db8::/126")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if expected := "10.0.0.0,28/32\n10.0.0.15,28/32\n2001:db8::,126/128\n2001:db8::3,126/128\n"; output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test the offset column, counted from the network address of each CIDR
+	output, err = runCommand(binPath, "-columns", "ip,offset", "-o", "-", "10.0.0.0/24", "10.0.1.7-10.0.1.8")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for i, expected := range map[int]string{0: "10.0.0.0,0", 5: "10.0.0.5,5", 255: "10.0.0.255,255", 256: "10.0.1.7,0", 257: "10.0.1.8,1"} {
+		if lines[i] != expected {
+			t.Errorf("Expected %q at line %d, got %q instead.", expected, i+1, lines[i])
+		}
+	}
+
+	// Test that unknown, repeated and missing columns fail at startup
+	checkError(t, binPath, "-columns", "ip,mac", "10.0.0.0/31")
+	checkError(t, binPath, "-columns", "ip,int,ip", "10.0.0.0/31")
+	checkError(t, binPath, "-columns", "int", "10.0.0.0/31")
+	checkError(t, binPath, "-columns", "ip,int", "-int", "10.0.0.0/31")
+
+	removeFiles(t)
+}
+
+func TestIPToInt(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"0.0.0.0", "0"},
+		{"10.0.0.0", "167772160"},
+		{"127.0.0.1", "2130706433"},
+		{"192.168.1.1", "3232235777"},
+		{"255.255.255.255", "4294967295"},
+		{"::1", "1"},
+		{"2001:db8::", "42540766411282592856903984951653826560"},
+	}
+
+	for _, tt := range tests {
+		if got := ipToInt(netip.MustParseAddr(tt.ip)); got != tt.expected {
+			t.Errorf("ipToInt(%s): expected %s, got %s instead.", tt.ip, tt.expected, got)
+		}
+	}
+}
+
+func TestPrefixLength(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"10.0.0.0/20", "20/32"},
+		{"10.0.0.5/28", "28/32"},
+		{"::ffff:10.0.0.0/120", "24/32"},
+		{"2001:db8::/64", "64/128"},
+		{"10.0.0.5", "32/32"},
+		{"2001:db8::5", "128/128"},
+		{"10.0.0.0-10.0.0.255", ""},
+		{"10.0.0.0+256", ""},
+	}
+
+	for _, test := range tests {
+		if result := prefixLength(test.source); result != test.expected {
+			t.Errorf("Expected %q for %s, got %q instead.", test.expected, test.source, result)
+		}
+	}
+}
+
+func TestAddrOffset(t *testing.T) {
+	tests := []struct {
+		first, addr string
+		expected    string
+	}{
+		{"10.0.0.0", "10.0.0.0", "0"},
+		{"10.0.0.0", "10.0.0.5", "5"},
+		{"10.0.0.0", "10.0.1.0", "256"},
+		{"0.0.0.0", "255.255.255.255", "4294967295"},
+		{"2001:db8::", "2001:db8::1:0", "65536"},
+		{"2001:db8::", "2001:db8:0:1::", "18446744073709551616"},
+		{"::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "340282366920938463463374607431768211455"},
+	}
+
+	for _, test := range tests {
+		if result := addrOffset(netip.MustParseAddr(test.first), netip.MustParseAddr(test.addr)); result != test.expected {
+			t.Errorf("Expected %s for %s from %s, got %s instead.", test.expected, test.addr, test.first, result)
+		}
+	}
+}
+
+func TestIPToHex(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"0.0.0.0", "00000000"},
+		{"10.0.0.1", "0A000001"},
+		{"192.168.1.255", "C0A801FF"},
+		{"::1", "00000000000000000000000000000001"},
+		{"2001:db8::ff00:42:8329", "20010DB8000000000000FF0000428329"},
+	}
+
+	for _, tt := range tests {
+		if got := ipToHex(netip.MustParseAddr(tt.ip)); got != tt.expected {
+			t.Errorf("ipToHex(%s): expected %s, got %s instead.", tt.ip, tt.expected, got)
+		}
+	}
+}
+
+func TestIPToMapped(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"0.0.0.0", "::ffff:0.0.0.0"},
+		{"10.0.0.1", "::ffff:10.0.0.1"},
+		{"192.168.1.255", "::ffff:192.168.1.255"},
+		{"::1", "::1"},
+		{"2001:db8::ff00:42:8329", "2001:db8::ff00:42:8329"},
+	}
+
+	for _, tt := range tests {
+		if got := ipToMapped(netip.MustParseAddr(tt.ip)); got != tt.expected {
+			t.Errorf("ipToMapped(%s): expected %s, got %s instead.", tt.ip, tt.expected, got)
+		}
+	}
+}
+
+func TestIPClass(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"0.0.0.0", "unspecified"},
+		{"::", "unspecified"},
+		{"127.0.0.1", "loopback"},
+		{"::1", "loopback"},
+		{"169.254.10.1", "link-local"},
+		{"fe80::1", "link-local"},
+		{"224.0.0.251", "multicast"},
+		{"ff02::1", "multicast"},
+		{"10.1.2.3", "private"},
+		{"172.16.0.1", "private"},
+		{"192.168.1.1", "private"},
+		{"fd00::1", "private"},
+		{"192.0.2.1", "documentation"},
+		{"198.51.100.7", "documentation"},
+		{"203.0.113.255", "documentation"},
+		{"2001:db8::1", "documentation"},
+		{"0.1.2.3", "reserved"},
+		{"255.255.255.255", "reserved"},
+		{"8.8.8.8", "global"},
+		{"2606:4700::1111", "global"},
+	}
+
+	for _, tt := range tests {
+		if got := ipClass(netip.MustParseAddr(tt.ip)); got != tt.expected {
+			t.Errorf("ipClass(%s): expected %s, got %s instead.", tt.ip, tt.expected, got)
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var buf strings.Builder
+	p := startProgress(&buf, big.NewInt(8))
+	for i := 0; i < 2; i++ {
+		p.add()
+	}
+	p.stop()
+
+	expected := "\r2 IPs generated (25.0%)\n"
+	if !strings.HasSuffix(buf.String(), expected) {
+		t.Errorf("Expected output ending in %q, got %q instead.", expected, buf.String())
+	}
+
+	// Test that nothing is drawn when stderr is not a terminal
+	buildBinary(t)
+
+	output, err := runCommand(binPath, "-o", "-", "10.0.0.0/30")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if strings.Contains(output, "IPs generated") {
+		t.Errorf("Expected no progress output, got %q instead.", output)
+	}
+
+	removeFiles(t)
+}
+
+func TestProgressLine(t *testing.T) {
+	// Test that the ETA extrapolates the throughput so far, here 5 IPs a second
+	p := newProgress(io.Discard, big.NewInt(100))
+	p.start = time.Now().Add(-10 * time.Second)
+	p.count.Store(50)
+	if got, expected := p.line(false), "50 IPs generated (50.0%, ETA 10s)"; got != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, got)
+	}
+
+	// Test that the final line goes without it and blanks out the longer one
+	var buf strings.Builder
+	p.w = &buf
+	p.draw(p.line(false))
+	p.draw(p.line(true))
+	if expected := "\r" + strings.Repeat(" ", len("50 IPs generated (50.0%, ETA 10s)")) + "\r50 IPs generated (50.0%)"; !strings.HasSuffix(buf.String(), expected) {
+		t.Errorf("Expected output ending in %q, got %q instead.", expected, buf.String())
+	}
+
+	// Test that a total too large to count falls back to the raw count
+	huge := new(big.Int).Lsh(big.NewInt(1), 96)
+	p = newProgress(io.Discard, huge)
+	p.count.Store(7)
+	if got, expected := p.line(false), "7 IPs generated"; got != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, got)
+	}
+
+	// Test that an unknown total, as filtered runs have, shows only the count
+	p = newProgress(io.Discard, nil)
+	p.count.Store(7)
+	if got, expected := p.line(true), "7 IPs generated"; got != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, got)
+	}
+}
+
+func TestFiltersIPs(t *testing.T) {
+	tests := []struct {
+		opts     options
+		expected bool
+	}{
+		{options{}, false},
+		{options{excluded: mergeRanges(nil)}, false},
+		{options{excluded: []addrRange{{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.1")}}}, true},
+		{options{matchRE: regexp.MustCompile(`\.1$`)}, true},
+		{options{privateOnly: true}, true},
+		{options{publicOnly: true}, true},
+		{options{family: 4, limit: 10}, false},
+	}
+
+	for i, tt := range tests {
+		if got := filtersIPs(&tt.opts); got != tt.expected {
+			t.Errorf("Test %d: expected %v, got %v instead.", i, tt.expected, got)
+		}
+	}
+}
+
+func TestVerbose(t *testing.T) {
+	buildBinary(t)
+
+	// Test that -V logs each CIDR and the totals to stderr only
+	for _, flag := range []string{"-V", "-verbose"} {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(binPath, flag, "-o", "-", "10.0.0.0/30", "10.0.1.0/31")
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("Command failed with error: %v", err)
+		}
+
+		expected := "10.0.0.0\n10.0.0.1\n10.0.0.2\n10.0.0.3\n10.0.1.0\n10.0.1.1\n"
+		if stdout.String() != expected {
+			t.Errorf("Expected %q on stdout with %s, got %q instead.", expected, flag, stdout.String())
+		}
+		for _, pattern := range []string{`10\.0\.0\.0/30: 4 IPs in \S+\n`, `10\.0\.1\.0/31: 2 IPs in \S+\n`, `total: 6 IPs from 2 CIDR\(s\) in \S+\n$`} {
+			if !regexp.MustCompile(pattern).MatchString(stderr.String()) {
+				t.Errorf("Expected stderr to match %q with %s, got %q instead.", pattern, flag, stderr.String())
+			}
+		}
+	}
+
+	// Test that nothing is logged by default
+	output, err := runCommand(binPath, "-o", "-", "10.0.0.0/30")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if strings.Contains(output, "IPs in") {
+		t.Errorf("Expected no log lines without -V, got %q instead.", output)
+	}
+
+	removeFiles(t)
+}
+
+func TestNoColor(t *testing.T) {
+	tests := []struct {
+		noColor  string
+		tty      bool
+		expected bool
+	}{
+		{"", true, true},
+		{"1", true, false},
+		{"0", true, false},
+		{"", false, false},
+		{"1", false, false},
+	}
+
+	for _, test := range tests {
+		if result := shouldColor(test.noColor, test.tty); result != test.expected {
+			t.Errorf("Expected %v for NO_COLOR=%q on a terminal=%v, got %v instead.", test.expected, test.noColor, test.tty, result)
+		}
+	}
+
+	// Test that warnings carry no ANSI escapes with NO_COLOR set
+	buildBinary(t)
+
+	cmd := exec.Command(binPath, "-warn-overlap", "-o", "-", "10.0.0.0/30", "10.0.0.2/31")
+	cmd.Env = append(os.Environ(), "NO_COLOR=1")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if !strings.Contains(string(output), "Warning: 10.0.0.0/30 overlaps 10.0.0.2/31") {
+		t.Errorf("Expected a warning, got %q instead.", output)
+	}
+	if bytes.Contains(output, []byte("\x1b[")) {
+		t.Errorf("Expected no ANSI escapes, got %q instead.", output)
+	}
+
+	removeFiles(t)
+}
+
+func TestQuiet(t *testing.T) {
+	buildBinary(t)
+
+	// Test that -q silences the success message but still writes the file
+	file := "quiet.csv"
+	for _, flag := range []string{"-q", "-quiet"} {
+		output, err := runCommand(binPath, flag, "-force", "-o", file, "10.0.0.0/30")
+		if err != nil {
+			t.Fatalf("Command failed with error: %v", err)
+		}
+		if output != "" {
+			t.Errorf("Expected no output with %s, got %q instead.", flag, output)
+		}
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("Expected %s to be written: %v", file, err)
+		}
+	}
+
+	// Test that errors are still reported
+	output, err := runCommand(binPath, "-q", "10.0.0.0/33")
+	if err == nil || !strings.Contains(output, "Error:") {
+		t.Errorf("Expected an error message, got %q instead.", output)
+	}
+
+	removeFiles(t, file)
+}
+
+func TestSplit(t *testing.T) {
+	buildBinary(t)
+
+	// Test splitting CIDRs into smaller subnets
+	output, err := runCommand(binPath, "-split", "/26", "10.0.0.0/24", "192.168.0.0/26")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "10.0.0.0/26\n10.0.0.64/26\n10.0.0.128/26\n10.0.0.192/26\n192.168.0.0/26\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that bare addresses and ranges are split as the block they cover
+	output, err = runCommand(binPath, "-split", "/32", "10.0.0.9", "10.0.1.0-10.0.1.1")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if expected := "10.0.0.9/32\n10.0.1.0/32\n10.0.1.1/32\n"; output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test with a prefix shorter than the CIDR's
+	checkError(t, binPath, "-split", "/16", "10.0.0.0/24")
+
+	// Test that an entry that can't be split is reported with its position
+	output, err = runCommand(binPath, "-split", "/32", "10.0.0.0/31", "10.0.0.1-10.0.0.5")
+	if err == nil || !strings.Contains(output, "argument 2: 10.0.0.1-10.0.0.5 is not a single CIDR block") {
+		t.Errorf("Expected a positioned error, got %q (%v) instead.", output, err)
+	}
+
+	removeFiles(t)
+}
+
+func TestIPv6(t *testing.T) {
+	buildBinary(t)
+
+	// Test expanding a small IPv6 block
+	output, err := runCommand(binPath, "-o", "-", "2001:db8::/126")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "2001:db8::\n2001:db8::1\n2001:db8::2\n2001:db8::3\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that a huge IPv6 block is refused before anything is written
+	output, err = runCommand(binPath, "-o", "-", "2001:db8::/64")
+	if err == nil {
+		t.Error("Expected an error, but command succeeded.")
+	}
+	if !strings.Contains(output, "-max-ips") {
+		t.Errorf("Expected an error mentioning -max-ips, got %q instead.", output)
+	}
+
+	// Test that the threshold is configurable
+	checkError(t, binPath, "-max-ips", "2", "-o", "-", "2001:db8::/126")
+	if _, err := runCommand(binPath, "-max-ips", "4", "-o", "-", "2001:db8::/126"); err != nil {
+		t.Errorf("Command failed with error: %v", err)
+	}
+
+	removeFiles(t)
+}
+
+func TestMaxIPs(t *testing.T) {
+	buildBinary(t)
+
+	// Test that the limit applies to the total over all inputs, IPv4 too
+	output, err := runCommand(binPath, "-max-ips", "6", "-o", "-", "10.0.0.0/30", "10.0.1.0/30")
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitUsage {
+		t.Errorf("Expected exit code %d, got %v instead.", exitUsage, err)
+	}
+	if !strings.Contains(output, "8 IPs") {
+		t.Errorf("Expected an error with the total, got %q instead.", output)
+	}
+
+	// Test that the IPs overlapping CIDRs share are counted once with -dedup and -merge
+	for _, flag := range []string{"-dedup", "-merge"} {
+		if output, err := runCommand(binPath, flag, "-max-ips", "4", "-o", "-", "10.0.0.0/30", "10.0.0.2/31"); err != nil {
+			t.Errorf("%s: command failed with error: %v: %s", flag, err, output)
+		}
+	}
+	checkError(t, binPath, "-max-ips", "4", "-o", "-", "10.0.0.0/30", "10.0.0.2/31")
+
+	// Test staying under the limit, including once -limit is taken into account
+	if _, err := runCommand(binPath, "-max-ips", "8", "-o", "-", "10.0.0.0/30", "10.0.1.0/30"); err != nil {
+		t.Errorf("Command failed with error: %v", err)
+	}
+	if _, err := runCommand(binPath, "-max-ips", "6", "-limit", "6", "-o", "-", "10.0.0.0/30", "10.0.1.0/30"); err != nil {
+		t.Errorf("Command failed with error: %v", err)
+	}
+
+	// Test that a /0 is refused by default before anything is generated
+	start := time.Now()
+	checkError(t, binPath, "-o", "-", "0.0.0.0/0")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected a fast failure, took %v instead.", elapsed)
+	}
+
+	removeFiles(t)
+}
+
+func TestFamily(t *testing.T) {
+	buildBinary(t)
+
+	file := "mixed.txt"
+	content := "10.0.0.0/31\n2001:db8::/127\n::ffff:192.168.0.0/127\n192.168.1.1-192.168.1.2\nfe80::1\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create CIDR file: %v", err)
+	}
+
+	// Test keeping only the IPv4 CIDRs, IPv4-mapped ones included
+	output, err := runCommand(binPath, "-family", "4", "-o", "-", "-f", file)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "Warning: skipped 2 CIDR(s) not of IPv4\n10.0.0.0\n10.0.0.1\n192.168.0.0\n192.168.0.1\n192.168.1.1\n192.168.1.2\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test keeping only the IPv6 ones, quietly
+	output, err = runCommand(binPath, "-family", "6", "-q", "-o", "-", "-f", file)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected = "2001:db8::\n2001:db8::1\nfe80::1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test an unknown family and an input left empty by the filter
+	checkError(t, binPath, "-family", "5", "10.0.0.0/31")
+	checkError(t, binPath, "-family", "6", "10.0.0.0/31")
+
+	removeFiles(t, file)
+}
+
+func TestPrivatePublicFilter(t *testing.T) {
+	buildBinary(t)
+
+	args := []string{"-o", "-", "9.255.255.254-10.0.0.1", "127.0.0.1/32"}
+
+	// Test keeping only private addresses
+	output, err := runCommand(binPath, append([]string{"-private-only"}, args...)...)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "10.0.0.0\n10.0.0.1\n127.0.0.1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test keeping only public addresses
+	output, err = runCommand(binPath, append([]string{"-public-only"}, args...)...)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected = "9.255.255.254\n9.255.255.255\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that the filters are mutually exclusive
+	checkError(t, binPath, "-private-only", "-public-only", "10.0.0.0/30")
+
+	removeFiles(t)
+}
+
+func TestExclude(t *testing.T) {
+	buildBinary(t)
+
+	// Test excluding a single IP, a range and a CIDR
+	output, err := runCommand(binPath, "-o", "-", "-exclude", "10.0.0.1", "-exclude", "10.0.0.3-10.0.0.5",
+		"-exclude", "10.0.0.8/30", "10.0.0.0/28")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "10.0.0.0\n10.0.0.2\n10.0.0.6\n10.0.0.7\n10.0.0.12\n10.0.0.13\n10.0.0.14\n10.0.0.15\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that an exclusion of the other family leaves everything in place
+	output, err = runCommand(binPath, "-o", "-", "-exclude", "::/0", "10.0.0.0/31")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected = "10.0.0.0\n10.0.0.1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that an invalid exclusion is reported
+	output, err = runCommand(binPath, "-o", "-", "-exclude", "10.0.0.300", "10.0.0.0/30")
+	if err == nil {
+		t.Error("Expected an error, but command succeeded.")
+	}
+	if !strings.Contains(output, "10.0.0.300") {
+		t.Errorf("Expected the error to name the exclusion, got %q instead.", output)
+	}
+
+	removeFiles(t)
+}
+
+func TestExcludeFile(t *testing.T) {
+	buildBinary(t)
+
+	file := "deny.txt"
+	content := "# blocklist\n\n10.0.0.16/28\n10.0.0.100-10.0.0.101 # a range\n10.0.0.200\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	// Test expanding a /24 while excluding a /28, a range and an IP from a file
+	output, err := runCommand(binPath, "-o", "-", "-exclude-file", file, "-exclude", "10.0.0.0", "10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 256-16-2-1-1 {
+		t.Errorf("Expected %d IPs, got %d instead.", 256-16-2-1-1, len(lines))
+	}
+	for _, ip := range lines {
+		addr := netip.MustParseAddr(ip)
+		if netip.MustParsePrefix("10.0.0.16/28").Contains(addr) || ip == "10.0.0.100" || ip == "10.0.0.101" || ip == "10.0.0.200" || ip == "10.0.0.0" {
+			t.Errorf("Expected %s to be excluded", ip)
+		}
+	}
+	if lines[0] != "10.0.0.1" || lines[14] != "10.0.0.15" || lines[15] != "10.0.0.32" {
+		t.Errorf("Expected the /28 to be skipped, got %q instead.", lines[:16])
+	}
+
+	// Test that an invalid entry is reported with its line
+	if err := os.WriteFile(file, []byte("10.0.0.0/28\n10.0.0.300\n"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	output, err = runCommand(binPath, "-o", "-", "-exclude-file", file, "10.0.0.0/24")
+	if err == nil {
+		t.Error("Expected an error, but command succeeded.")
+	}
+	if !strings.Contains(output, "deny.txt:2") {
+		t.Errorf("Expected the error to point at deny.txt:2, got %q instead.", output)
+	}
+
+	// Test that a missing file is an error
+	checkError(t, binPath, "-o", "-", "-exclude-file", "missing.txt", "10.0.0.0/24")
+
+	removeFiles(t, file)
+}
+
+func TestMergeRanges(t *testing.T) {
+	ranges := []addrRange{
+		{netip.MustParseAddr("10.0.0.8"), netip.MustParseAddr("10.0.0.15")},
+		{netip.MustParseAddr("::"), netip.MustParseAddr("::ff")},
+		{netip.MustParseAddr("10.0.0.0"), netip.MustParseAddr("10.0.0.7")},
+		{netip.MustParseAddr("10.0.0.10"), netip.MustParseAddr("10.0.0.12")},
+		{netip.MustParseAddr("10.0.0.20"), netip.MustParseAddr("255.255.255.255")},
+	}
+
+	merged := mergeRanges(ranges)
+	expected := []addrRange{
+		{netip.MustParseAddr("10.0.0.0"), netip.MustParseAddr("10.0.0.15")},
+		{netip.MustParseAddr("10.0.0.20"), netip.MustParseAddr("255.255.255.255")},
+		{netip.MustParseAddr("::"), netip.MustParseAddr("::ff")},
+	}
+	if fmt.Sprint(merged) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v instead.", expected, merged)
+	}
+
+	tests := []struct {
+		addr     string
+		expected bool
+	}{
+		{"9.255.255.255", false},
+		{"10.0.0.0", true},
+		{"10.0.0.15", true},
+		{"10.0.0.16", false},
+		{"255.255.255.255", true},
+		{"::80", true},
+		{"::100", false},
+	}
+
+	for _, test := range tests {
+		if result := isExcluded(netip.MustParseAddr(test.addr), merged); result != test.expected {
+			t.Errorf("Expected %v for %s, got %v instead.", test.expected, test.addr, result)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	buildBinary(t)
+
+	// Test pulling the .1 address out of several subnets
+	output, err := runCommand(binPath, "-match", `\.1$`, "-o", "-", "10.0.0.0/24", "10.0.1.0/24", "192.168.0.0/30")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "10.0.0.1\n10.0.1.1\n192.168.0.1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that an invalid pattern fails before any file is created
+	output, err = runCommand(binPath, "-match", `[`, "10.0.0.0/24")
+	if err == nil {
+		t.Error("Expected an error, but command succeeded.")
+	}
+	if strings.Contains(output, "IP list saved") {
+		t.Errorf("Expected no output file, got %q instead.", output)
+	}
+
+	removeFiles(t)
+}
+
+func TestIsPrivateIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		private bool
+	}{
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"172.32.0.1", false},
+		{"192.168.1.1", true},
+		{"127.0.0.1", true},
+		{"169.254.10.20", true},
+		{"8.8.8.8", false},
+		{"::1", true},
+		{"fe80::1", true},
+		{"fd12:3456::1", true},
+		{"2001:4860:4860::8888", false},
+	}
+
+	for _, tt := range tests {
+		if got := isPrivateIP(netip.MustParseAddr(tt.ip)); got != tt.private {
+			t.Errorf("isPrivateIP(%s): expected %v, got %v instead.", tt.ip, tt.private, got)
+		}
+	}
+}
+
+func TestGzipOutput(t *testing.T) {
+	buildBinary(t)
+
+	// Test that an output filename ending in .gz is written compressed
+	file := "output.csv.gz"
+	if _, err := runCommand(binPath, "-o", file, "10.0.0.0/24"); err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("Error opening file: %v", err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Output is not gzip-compressed: %v", err)
+	}
+
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("Error decompressing file: %v", err)
+	}
+	if count := strings.Count(string(data), "\n"); count != 256 {
+		t.Errorf("Expected 256 IP addresses, but found %d", count)
+	}
+
+	removeFiles(t, file)
+}
+
+func TestWildcard(t *testing.T) {
+	buildBinary(t)
+
+	// Test the wildcard masks of a few common prefix lengths
+	output, err := runCommand(binPath, "-wildcard", "172.16.5.0/16", "192.168.1.0/24", "192.168.1.4/30")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "172.16.0.0 0.0.255.255\n192.168.1.0 0.0.0.255\n192.168.1.4 0.0.0.3\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that bare addresses and ranges covering a single block have a mask
+	output, err = runCommand(binPath, "-wildcard", "10.0.0.7", "10.0.1.0-10.0.1.255", "2001:db8::+4")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if expected := "10.0.0.7 0.0.0.0\n10.0.1.0 0.0.0.255\n2001:db8:: ::3\n"; output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that other ranges, which have no mask, are rejected with their position
+	output, err = runCommand(binPath, "-wildcard", "10.0.0.0/24", "10.0.0.1-10.0.0.5")
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitParse || !strings.Contains(output, "argument 2: 10.0.0.1-10.0.0.5 is not a single CIDR block") {
+		t.Errorf("Expected a positioned error, got %q (%v) instead.", output, err)
+	}
+
+	removeFiles(t)
+}
+
+func TestContains(t *testing.T) {
+	buildBinary(t)
+
+	// Test that only the inputs containing the address are printed, as given
+	output, err := runCommand(binPath, "-contains", "10.0.1.5", "10.0.0.0/24", "10.0.0.0/16", "10.0.1.0-10.0.1.9", "192.168.0.0/16", "2001:db8::/32")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if expected := "10.0.0.0/16\n10.0.1.0-10.0.1.9\n"; output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that an address in none of them fails with nothing printed on stdout
+	cmd := exec.Command(binPath, "-contains", "172.16.0.1", "10.0.0.0/8", "192.168.0.0/16")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitFailure {
+		t.Errorf("Expected exit status %d, got %v instead.", exitFailure, err)
+	}
+	if stdout.Len() > 0 || !strings.Contains(stderr.String(), "no input CIDR contains 172.16.0.1") {
+		t.Errorf("Expected only an error on stderr, got %q and %q instead.", stdout.String(), stderr.String())
+	}
+
+	// Test that an invalid address or CIDR is reported
+	if _, err := runCommand(binPath, "-contains", "10.0.0.256", "10.0.0.0/8"); !errors.As(err, &exitErr) || exitErr.ExitCode() != exitUsage {
+		t.Errorf("Expected exit status %d, got %v instead.", exitUsage, err)
+	}
+	if _, err := runCommand(binPath, "-contains", "10.0.0.1", "10.0.0.0/33"); !errors.As(err, &exitErr) || exitErr.ExitCode() != exitParse {
+		t.Errorf("Expected exit status %d, got %v instead.", exitParse, err)
+	}
+
+	removeFiles(t)
+}
+
+func TestCount(t *testing.T) {
+	buildBinary(t)
+
+	// Test counting IPv4 and IPv6 CIDRs without expanding them
+	output, err := runCommand(binPath, "-count", "10.0.0.0/8", "192.168.0.0/30", "2001:db8::/64")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	for _, expected := range []string{
+		"10.0.0.0/8      16777216",
+		"192.168.0.0/30  4",
+		"2001:db8::/64   18446744073709551616",
+		"Total           18446744073726328836",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected '%s' in output, got '%s' instead.", expected, output)
+		}
+	}
+
+	// Test that -hosts counts only the usable hosts
+	output, err = runCommand(binPath, "-count", "-hosts", "10.0.0.0/24", "10.0.1.0/30", "10.0.2.0/31", "10.0.3.1/32")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	for _, expected := range []string{
+		"CIDR         Hosts",
+		"10.0.0.0/24  254",
+		"10.0.1.0/30  2",
+		"10.0.2.0/31  2",
+		"10.0.3.1/32  1",
+		"Total        259",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected '%s' in output, got '%s' instead.", expected, output)
+		}
+	}
+
+	// Test counting an invalid CIDR
+	checkError(t, binPath, "-count", "10.0.0.0/33")
+
+	removeFiles(t)
+}
+
+func TestCountPerCIDR(t *testing.T) {
+	buildBinary(t)
+
+	args := []string{"-count-per-cidr", "10.0.0.0/24", "10.0.1.0/31", "10.0.2.1/32", "10.0.3.0-10.0.3.9", "2001:db8::/126"}
+	expected := "cidr,ips,usable\n10.0.0.0/24,256,254\n10.0.1.0/31,2,2\n10.0.2.1/32,1,1\n10.0.3.0-10.0.3.9,10,10\n2001:db8::/126,4,4\n"
+
+	// Test the table on stdout, /31 and /32 included
+	output, err := runCommand(binPath, args...)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test writing it to the output file instead
+	file := "counts.csv"
+	output, err = runCommand(binPath, append([]string{"-o", file}, args...)...)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if !strings.Contains(output, "Counts saved to "+file) {
+		t.Errorf("Expected the file to be reported, got %q instead.", output)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, data)
+	}
+
+	// Test that an invalid CIDR is an error
+	checkError(t, binPath, "-count-per-cidr", "10.0.0.0/33")
+
+	removeFiles(t, file)
+}
+
+func TestPrefixSummary(t *testing.T) {
+	buildBinary(t)
+
+	// Test the usable range of a /24, /30, /31 and /32
+	output, err := runCommand(binPath, "-prefix-summary", "10.0.0.0/24", "10.0.1.0/30", "10.0.2.0/31", "10.0.3.1/32", "2001:db8::/126")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "cidr,network,first_usable,last_usable,broadcast,hosts\n" +
+		"10.0.0.0/24,10.0.0.0,10.0.0.1,10.0.0.254,10.0.0.255,254\n" +
+		"10.0.1.0/30,10.0.1.0,10.0.1.1,10.0.1.2,10.0.1.3,2\n" +
+		"10.0.2.0/31,10.0.2.0,10.0.2.0,10.0.2.1,10.0.2.1,2\n" +
+		"10.0.3.1/32,10.0.3.1,10.0.3.1,10.0.3.1,10.0.3.1,1\n" +
+		"2001:db8::/126,2001:db8::,2001:db8::,2001:db8::3,2001:db8::3,4\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that host bits don't shift the summary off the network
+	output, err = runCommand(binPath, "-prefix-summary", "10.0.0.77/24")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	if !strings.Contains(output, "10.0.0.77/24,10.0.0.0,10.0.0.1,10.0.0.254,10.0.0.255,254\n") {
+		t.Errorf("Expected the summary of 10.0.0.0/24, got %q instead.", output)
+	}
+
+	checkError(t, binPath, "-prefix-summary", "10.0.0.0/33")
+
+	removeFiles(t)
+}
+
+func TestMaskFormat(t *testing.T) {
+	buildBinary(t)
+
+	// Test that -prefix-summary gets dotted masks, IPv6 ones as an address
+	output, err := runCommand(binPath, "-prefix-summary", "-mask-format", "dotted", "10.0.0.0/24", "10.0.16.0/20", "10.0.3.1", "2001:db8::/64")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	expected := "cidr,mask,network,first_usable,last_usable,broadcast,hosts\n" +
+		"10.0.0.0/24,255.255.255.0,10.0.0.0,10.0.0.1,10.0.0.254,10.0.0.255,254\n" +
+		"10.0.16.0/20,255.255.240.0,10.0.16.0,10.0.16.1,10.0.31.254,10.0.31.255,4094\n" +
+		"10.0.3.1,255.255.255.255,10.0.3.1,10.0.3.1,10.0.3.1,10.0.3.1,1\n" +
+		"2001:db8::/64,ffff:ffff:ffff:ffff::,2001:db8::,2001:db8::,2001:db8::ffff:ffff:ffff:ffff,2001:db8::ffff:ffff:ffff:ffff,18446744073709551616\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	// Test that -count-per-cidr gets prefix masks, and ranges an empty one
+	output, err = runCommand(binPath, "-count-per-cidr", "-mask-format", "prefix", "10.0.0.0/24", "10.0.16.0/20", "10.0.0.1-10.0.0.4")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	expected = "cidr,mask,ips,usable\n10.0.0.0/24,/24,256,254\n10.0.16.0/20,/20,4096,4094\n10.0.0.1-10.0.0.4,,4,4\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, output)
+	}
+
+	checkError(t, binPath, "-prefix-summary", "-mask-format", "hex", "10.0.0.0/24")
+
+	removeFiles(t)
+}
+
+func TestFormatMask(t *testing.T) {
+	tests := []struct {
+		cidr, format, expected string
+	}{
+		{"10.0.0.0/24", "dotted", "255.255.255.0"},
+		{"10.0.0.0/20", "dotted", "255.255.240.0"},
+		{"10.0.0.0/0", "dotted", "0.0.0.0"},
+		{"10.0.0.0/20", "prefix", "/20"},
+		{"::ffff:10.0.0.0/120", "dotted", "255.255.255.0"},
+		{"2001:db8::/32", "prefix", "/32"},
+		{"2001:db8::1", "prefix", "/128"},
+		{"10.0.0.1-10.0.0.9", "dotted", ""},
+	}
+
+	for _, tt := range tests {
+		if got := formatMask(tt.cidr, tt.format); got != tt.expected {
+			t.Errorf("formatMask(%s, %s): expected %q, got %q instead.", tt.cidr, tt.format, tt.expected, got)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	buildBinary(t)
+
+	// Test that every job writes its own file in its own format
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.csv"), filepath.Join(dir, "second.txt")
+	config := filepath.Join(dir, "batch.json")
+	data := fmt.Sprintf(`{"jobs": [
+		{"name": "first", "cidrs": ["10.0.0.0/31"], "output": %q},
+		{"name": "second", "cidrs": ["192.168.1.1", "192.168.1.2/32"], "output": %q, "format": "txt"}
+	]}`, first, second)
+	if err := os.WriteFile(config, []byte(data), 0644); err != nil {
+		t.Fatalf("Error writing config: %v", err)
+	}
+	output, err := runCommand(binPath, "-batch", config)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v\n%s", err, output)
+	}
+	for _, msg := range []string{"first: IP list saved to " + first, "second: IP list saved to " + second} {
+		if !strings.Contains(output, msg) {
+			t.Errorf("Expected %q in the output, got %q instead.", msg, output)
+		}
+	}
+	for file, expected := range map[string]string{first: "10.0.0.0\n10.0.0.1\n", second: "192.168.1.1\n192.168.1.2\n"} {
+		got, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Error reading file: %v", err)
+		}
+		if string(got) != expected {
+			t.Errorf("Expected %q in %s, got %q instead.", expected, file, got)
+		}
+	}
+
+	// Test that a failing job is reported, later jobs still run and the exit
+	// status is that of the failure
+	if err := os.Remove(second); err != nil {
+		t.Fatalf("Error removing file: %v", err)
+	}
+	data = fmt.Sprintf(`{"jobs": [
+		{"name": "broken", "cidrs": ["10.0.0.0/33"], "output": %q},
+		{"name": "second", "cidrs": ["192.168.1.1"], "output": %q, "format": "txt"}
+	]}`, first, second)
+	if err := os.WriteFile(config, []byte(data), 0644); err != nil {
+		t.Fatalf("Error writing config: %v", err)
+	}
+	output, err = runCommand(binPath, "-batch", config)
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitParse {
+		t.Errorf("Expected exit status %d, got %v instead.", exitParse, err)
+	}
+	if !strings.Contains(output, "Error: job broken: cidrs:1:") || !strings.Contains(output, "1 of 2 job(s) failed") {
+		t.Errorf("Expected the broken job to be reported, got %q instead.", output)
+	}
+	if _, err := os.Stat(second); err != nil {
+		t.Errorf("Expected the second job to run after the failure: %v", err)
+	}
+
+	// Test that an incomplete job is a usage error and nothing runs
+	if err := os.WriteFile(config, []byte(`{"jobs": [{"name": "first", "cidrs": ["10.0.0.0/31"]}]}`), 0644); err != nil {
+		t.Fatalf("Error writing config: %v", err)
+	}
+	_, err = runCommand(binPath, "-batch", config)
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitUsage {
+		t.Errorf("Expected exit status %d, got %v instead.", exitUsage, err)
+	}
+
+	// Test that -batch takes no other CIDRs
+	_, err = runCommand(binPath, "-batch", config, "10.0.0.0/31")
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitUsage {
+		t.Errorf("Expected exit status %d, got %v instead.", exitUsage, err)
+	}
+
+	// Test that relative paths are relative to the config, and that the input
+	// flags apply to each job
+	sub := filepath.Join(dir, "jobs")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, "lab.txt"), []byte("10.1.0.0/31\n2001:db8::/127\n"), 0644); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	config = filepath.Join(sub, "batch.json")
+	data = `{"jobs": [
+		{"name": "lab", "files": ["lab.txt"], "output": "lab.csv"},
+		{"name": "v6", "cidrs": ["2001:db8::/127"], "output": "v6.csv"}
+	]}`
+	if err := os.WriteFile(config, []byte(data), 0644); err != nil {
+		t.Fatalf("Error writing config: %v", err)
+	}
+	output, err = runCommand(binPath, "-batch", config, "-family", "4")
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitUsage || !strings.Contains(output, "job v6: no IPv4 CIDRs") {
+		t.Errorf("Expected the v6 job to fail with exit status %d, got %q (%v) instead.", exitUsage, output, err)
+	}
+	got, err := os.ReadFile(filepath.Join(sub, "lab.csv"))
+	if err != nil {
+		t.Fatalf("Error reading file: %v", err)
+	}
+	if expected := "10.1.0.0\n10.1.0.1\n"; string(got) != expected {
+		t.Errorf("Expected %q, got %q instead.", expected, got)
+	}
+	if _, err := os.Stat(filepath.Join(sub, "v6.csv")); !os.IsNotExist(err) {
+		t.Errorf("Expected no v6.csv, got %v instead.", err)
+	}
+
+	// Test that a duplicate CIDR fails its job with -no-dup-cidrs
+	data = `{"jobs": [{"name": "dups", "cidrs": ["10.0.0.0/31", "10.0.0.0/31"], "output": "dups.csv"}]}`
+	if err := os.WriteFile(config, []byte(data), 0644); err != nil {
+		t.Fatalf("Error writing config: %v", err)
+	}
+	output, err = runCommand(binPath, "-batch", config, "-no-dup-cidrs")
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitParse || !strings.Contains(output, "1 duplicate CIDR(s)") {
+		t.Errorf("Expected a duplicate CIDR error, got %q (%v) instead.", output, err)
+	}
+
+	// Test that -append adds to the file of each job
+	data = `{"jobs": [{"name": "grow", "cidrs": ["10.0.0.0/31"], "output": "grow.csv"}]}`
+	if err := os.WriteFile(config, []byte(data), 0644); err != nil {
+		t.Fatalf("Error writing config: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if output, err := runCommand(binPath, "-batch", config, "-append"); err != nil {
+			t.Fatalf("Command failed with error: %v: %s", err, output)
+		}
+	}
+	got, err = os.ReadFile(filepath.Join(sub, "grow.csv"))
+	if expected := "10.0.0.0\n10.0.0.1\n10.0.0.0\n10.0.0.1\n"; err != nil || string(got) != expected {
+		t.Errorf("Expected %q, got %q (%v) instead.", expected, got, err)
+	}
+	data = `{"jobs": [{"name": "out", "cidrs": ["10.0.0.0/31"], "output": "-"}]}`
+	if err := os.WriteFile(config, []byte(data), 0644); err != nil {
+		t.Fatalf("Error writing config: %v", err)
+	}
+	output, err = runCommand(binPath, "-batch", config, "-append")
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitUsage || !strings.Contains(output, "job out: -append requires an output file") {
+		t.Errorf("Expected an -append error, got %q (%v) instead.", output, err)
+	}
+
+	// Test that the flags of other modes, and those placing the output, are
+	// refused instead of ignored
+	for _, flags := range [][]string{{"-count"}, {"-dry-run"}, {"-json-summary", "-"}, {"-f", config}, {"-o", "x.csv"}, {"-outdir", dir}} {
+		output, err := runCommand(binPath, append([]string{"-batch", config}, flags...)...)
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitUsage || !strings.Contains(output, "-batch can't be used with "+flags[0]) {
+			t.Errorf("%s: expected exit status %d, got %q (%v) instead.", flags[0], exitUsage, output, err)
+		}
+	}
+
+	removeFiles(t)
+}
+
+func buildBinary(t *testing.T) {
+	cmd := exec.Command("go", "build", ".")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to build binary: %v", err)
+	}
+}
+
+func checkCmdOutput(t *testing.T, b string, args ...string) string {
+	output, err := runCommand(b, args...)
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	expected := "IP list saved to cidr2ip_"
+	if !strings.Contains(string(output), expected) {
+		t.Errorf("Expected '%s', got '%s' instead.", expected, output)
+	}
+
+	return extractFileName(output, "IP list saved to (.+\\.csv)")
+}
+
+func runCommand(b string, args ...string) (string, error) {
+	cmd := exec.Command(b, args...)
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+func runCommandWithInput(input, b string, args ...string) (string, error) {
+	cmd := exec.Command(b, args...)
+	cmd.Stdin = strings.NewReader(input)
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+func extractFileName(output, pattern string) string {
+	re := regexp.MustCompile(pattern)
+	match := re.FindStringSubmatch(output)
+	if len(match) != 2 {
+		log.Fatalf("Failed to extract filename from output: %s", output)
+	}
+
+	return match[1]
+}
+
+func removeFiles(t *testing.T, files ...string) {
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			t.Logf("Error removing file: %v", err)
+		}
+	}
+
+	if err := os.Remove(binPath); err != nil {
+		t.Logf("Failed to remove binary file: %v", err)
+	}
+}
+
+func createEmptyFile(file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return nil
+}
+
+func checkError(t *testing.T, b string, args ...string) {
+	cmd := exec.Command(b, args...)
+	err := cmd.Run()
+	if err == nil {
+		t.Error("Expected an error, but command succeeded.")
+	}
+}
@@ -0,0 +1,1042 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"unicode/utf8"
+
+	"github.com/rcmelendez/cidr2ip"
+)
+
+// formats maps each supported output format to its writer. The format name
+// doubles as the output file extension.
+var formats = map[string]func(w io.Writer, columns []string, opts *options) ipWriter{
+	"csv":      newCSVWriter,
+	"hosts":    newHostsWriter,
+	"inline":   newInlineWriter,
+	"json":     newJSONWriter,
+	"ndjson":   newNDJSONWriter,
+	"nmap":     newNmapWriter,
+	"parquet":  newParquetWriter,
+	"sql":      newSQLWriter,
+	"template": newTemplateWriter,
+	"txt":      newTextWriter,
+}
+
+// formatNames returns the supported output formats, sorted and comma-separated.
+func formatNames() string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// errLimitReached stops the generation early once -limit IPs are written.
+var errLimitReached = errors.New("limit reached")
+
+// interruptCheckInterval is how many IPs are generated between checks for an
+// interrupt.
+const interruptCheckInterval = 4096
+
+// ipWriter receives the output rows one at a time, so the full list never
+// has to be held in memory. Each row holds one value per output column, the
+// IP first. Flush must be called once all rows are written.
+type ipWriter interface {
+	WriteRow(row []string) error
+	Flush() error
+}
+
+func isStdout(file string) bool {
+	return file == "-" || file == "/dev/stdout"
+}
+
+// writeOutput writes the IPs of cidrs to file as selected in opts and returns
+// the name of the file actually written, which differs from file when that
+// was taken. If st is not nil, it is updated with the figures of the run.
+// Once ctx is done, the IPs written so far are flushed and the file closed,
+// and an error saying how many there were is returned.
+func writeOutput(ctx context.Context, cidrs []string, file string, opts *options, st *runStats) (written string, err error) {
+	var dups *dupChecker
+	// Rewritten CIDRs never overlap, so only the original list can repeat IPs
+	if st != nil && !opts.merge && !opts.dedup {
+		if dups, err = newDupChecker(cidrs); err != nil {
+			return "", err
+		}
+	}
+
+	// sources holds the input entry each of cidrs is reported as by -with-cidr
+	sources := cidrs
+	switch {
+	case opts.merge:
+		cidrs, err = cidr2ip.MergeCIDRs(cidrs)
+		sources = cidrs
+	case opts.dedup:
+		var (
+			deduped []string
+			owners  []int
+		)
+		deduped, err = cidr2ip.DedupCIDRs(cidrs)
+		if err == nil {
+			owners, err = dedupOwners(cidrs, deduped)
+		}
+		if err == nil {
+			sources = make([]string, len(deduped))
+			for i, owner := range owners {
+				sources[i] = cidrs[owner]
+			}
+			cidrs = deduped
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		out io.Writer = os.Stdout
+		gz  *gzip.Writer
+	)
+
+	var f *outputFile
+	if !isStdout(file) {
+		if f, err = openOutput(file, opts); err != nil {
+			return "", err
+		}
+		defer f.abort()
+
+		// A file being appended to already starts with its header, if any
+		if opts.appendOutput && opts.header {
+			info, err := f.Stat()
+			if err != nil {
+				return "", err
+			}
+			if info.Size() > 0 {
+				o := *opts
+				o.header = false
+				opts = &o
+			}
+		}
+
+		out = f
+		if strings.HasSuffix(file, ".gz") {
+			gz = gzip.NewWriter(f)
+			out = gz
+		}
+	}
+
+	columns := opts.columnList
+	w := formats[opts.format](out, columns, opts)
+	// The ptr column is left empty by the row builder for the resolver to fill
+	if ptr := slices.Index(columns, "ptr"); ptr >= 0 {
+		w = newResolver(w, opts.resolveTimeout, slices.Index(columns, "ip"), ptr)
+	}
+
+	var each eachFunc = cidr2ip.EachAddr
+	jobs := opts.jobs
+	switch {
+	case opts.onlyNetwork:
+		each = eachNetwork
+	case opts.boundaries && opts.usableHosts:
+		each = eachUsableBoundary
+	case opts.boundaries:
+		each = eachBoundary
+	case opts.tail > 0:
+		each = eachTail(opts.tail)
+	case opts.every > 0:
+		each = eachEvery(opts.every)
+	case opts.sample > 0:
+		each = newSampler(opts.sample, opts.seed, opts.quiet).each
+		// The sampler's random source is shared, and -seed must stay reproducible
+		jobs = 1
+	}
+	if opts.logger != nil {
+		each = opts.logger.wrap(each)
+	}
+
+	// Progress is drawn on stderr, and only when that is a terminal which
+	// isn't also displaying the IP list itself
+	var p *progress
+	if !opts.quiet && !opts.noProgress && isTerminal(os.Stderr) && !(isStdout(file) && isTerminal(os.Stdout)) {
+		// The filters would keep a percentage from ever reaching 100, so
+		// only the count is shown with them
+		total := opts.total
+		if filtersIPs(opts) {
+			total = nil
+		} else if total == nil {
+			if total, err = expectedIPs(cidrs, opts); err != nil {
+				return "", err
+			}
+		}
+		p = startProgress(os.Stderr, total)
+		defer p.stop()
+	}
+
+	rows := 0
+	buildRow := newRowBuilder(columns, sources)
+	writeRow := func(i int, addr netip.Addr) error {
+		rows++
+		return w.WriteRow(buildRow(i, addr))
+	}
+
+	var (
+		n, seen int
+		held    []sourcedAddr
+	)
+	err = generateIPs(cidrs, each, jobs, func(i int, addr netip.Addr) error {
+		if seen++; seen%interruptCheckInterval == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Checked first, so a filter dropping most IPs doesn't keep the walk going
+		if opts.limit > 0 && n >= opts.limit {
+			return errLimitReached
+		}
+		if !keepIP(addr, opts) {
+			return nil
+		}
+		n++
+		if p != nil {
+			p.add()
+		}
+		if st != nil {
+			st.ips++
+			if dups != nil && dups.isDup(i, addr) {
+				st.duplicates++
+			}
+		}
+
+		if opts.sort || opts.shuffle {
+			held = append(held, sourcedAddr{addr, i})
+			return nil
+		}
+		return writeRow(i, addr)
+	})
+	interrupted := ctx.Err() != nil && errors.Is(err, ctx.Err())
+	if err != nil && !errors.Is(err, errLimitReached) && !interrupted {
+		return "", err
+	}
+
+	// Sorting and shuffling need every IP at once, so they are the modes that
+	// don't stream
+	if (opts.sort || opts.shuffle) && !interrupted {
+		if opts.sort {
+			sort.SliceStable(held, func(a, b int) bool {
+				return held[a].addr.Less(held[b].addr)
+			})
+		} else {
+			newRand(opts.seed).Shuffle(len(held), func(a, b int) {
+				held[a], held[b] = held[b], held[a]
+			})
+		}
+		for _, s := range held {
+			if err := writeRow(s.source, s.addr); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+
+	// Closing the gzip stream writes its footer, without which the file is truncated
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return "", err
+		}
+	}
+
+	// Even when interrupted, as what was written so far is complete lines
+	if f != nil {
+		if file, err = f.commit(); err != nil {
+			return "", err
+		}
+	}
+
+	if interrupted {
+		name := file
+		if isStdout(file) {
+			name = "stdout"
+		}
+		return "", withCode(exitInterrupted, fmt.Errorf("interrupted after writing %d IPs to %s", rows, name))
+	}
+
+	return file, nil
+}
+
+// writeSplitFiles writes the IPs of each CIDR to its own file in -outdir and
+// returns the names of the files written, in input order. Each file is
+// written independently, so -limit and the like apply to every file rather
+// than to the whole run, and up to -j of them are written at once, each with
+// its own writer. Every file is attempted even if another fails, and the
+// errors of all those that did are returned together.
+func writeSplitFiles(ctx context.Context, cidrs []string, opts *options, st *runStats) ([]string, error) {
+	dir, err := filepath.Abs(opts.outdir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	// Each file has a progress line of its own, with its own total, but
+	// concurrent ones would overwrite one another
+	o := *opts
+	o.total = nil
+	jobs := min(opts.jobs, len(cidrs))
+	if jobs > 1 {
+		o.noProgress = true
+	}
+
+	var (
+		files = make([]string, len(cidrs))
+		errs  = make([]error, len(cidrs))
+		sem   = make(chan struct{}, max(jobs, 1))
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+	)
+	for i, cidr := range cidrs {
+		if ctx.Err() != nil {
+			errs[i] = withCode(exitInterrupted, fmt.Errorf("interrupted before writing %s", cidr))
+			break
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			// The figures of each file are added to st once it is written,
+			// as runStats isn't safe for concurrent use
+			var fileSt *runStats
+			if st != nil {
+				fileSt = &runStats{}
+			}
+			name := filepath.Join(dir, splitFileName(cidr)+"."+opts.format)
+			files[i], errs[i] = writeOutput(ctx, []string{cidr}, name, &o, fileSt)
+			if st != nil {
+				mu.Lock()
+				st.ips += fileSt.ips
+				st.duplicates += fileSt.duplicates
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// splitFileName returns the base name of the -split-files file of cidr, such
+// as 10.0.0.0_24 for 10.0.0.0/24. Colons become dashes so IPv6 names are
+// valid on every platform.
+func splitFileName(cidr string) string {
+	return strings.NewReplacer("/", "_", ":", "-", " ", "").Replace(cidr)
+}
+
+// outputFile is an output file being written. Unless appending, the IPs go
+// to a temporary file next to it, which commit moves into place once it is
+// complete, so readers never see a half-written file and a failed run leaves
+// nothing behind but what was there before.
+type outputFile struct {
+	*os.File
+	name    string // the file to create, or being appended to
+	opts    *options
+	inPlace bool // written directly, without a temporary file
+	done    bool
+}
+
+// openOutput opens file for writing as selected in opts. Without -force, an
+// explicit -o target that already exists is an error right away, before any
+// IP is generated, instead of once they all are.
+func openOutput(file string, opts *options) (*outputFile, error) {
+	if opts.appendOutput {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return &outputFile{File: f, name: file, opts: opts, inPlace: true}, nil
+	}
+
+	// Devices such as /dev/null and pipes must be written through rather than
+	// replaced, and are never taken: only regular files already exist
+	info, err := os.Stat(file)
+	if err == nil && !info.Mode().IsRegular() {
+		return openInPlace(file, opts)
+	}
+	if err == nil && !opts.force && opts.output != "" {
+		return nil, errFileExists(file)
+	}
+	// A symlink -force overwrites points at the file to write, and stays
+	if link, err := os.Lstat(file); err == nil && opts.force && link.Mode()&os.ModeSymlink != 0 {
+		return openInPlace(file, opts)
+	}
+
+	f, err := createTemp(file)
+	if err != nil {
+		// The temporary name means nothing to the user, who asked for file
+		var pe *os.PathError
+		if errors.As(err, &pe) {
+			err = &os.PathError{Op: "create", Path: file, Err: pe.Err}
+		}
+		return nil, err
+	}
+	// A file -force replaces keeps its permissions, whatever the umask
+	if info != nil && opts.force {
+		if err := f.Chmod(info.Mode().Perm()); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, err
+		}
+	}
+
+	return &outputFile{File: f, name: file, opts: opts}, nil
+}
+
+// createTemp creates a new temporary file to be renamed to file. It is in the
+// same directory, so the rename never crosses devices, and unlike with
+// os.CreateTemp, which makes it 0600, the umask decides its permissions, as
+// it would for os.Create.
+func createTemp(file string) (*os.File, error) {
+	for tries := 0; ; tries++ {
+		name := filepath.Join(filepath.Dir(file), "."+filepath.Base(file)+"."+strconv.FormatUint(uint64(rand.Uint32()), 10)+".tmp")
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if errors.Is(err, os.ErrExist) && tries < 100 {
+			continue
+		}
+		return f, err
+	}
+}
+
+// openInPlace opens file, which exists, to be written directly.
+func openInPlace(file string, opts *options) (*outputFile, error) {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &outputFile{File: f, name: file, opts: opts, inPlace: true}, nil
+}
+
+func errFileExists(file string) error {
+	return withCode(exitIO, fmt.Errorf("file already exists: %s (use -force to overwrite or -append to add to it)", file))
+}
+
+// commit closes the file and moves it into place, returning its actual name.
+// Without -force, an existing file is never replaced: an explicit -o target
+// is an error, while a timestamped name already taken by a run in the same
+// second gets a numeric suffix, such as cidr2ip_..._1.csv, instead.
+func (o *outputFile) commit() (string, error) {
+	o.done = true
+	// Close can report a write the kernel only failed to complete now, so its
+	// error matters as much as the ones from the writes themselves
+	if err := o.Close(); err != nil {
+		o.remove()
+		return "", err
+	}
+	if o.inPlace {
+		return o.name, nil
+	}
+
+	if o.opts.force {
+		if err := os.Rename(o.Name(), o.name); err != nil {
+			o.remove()
+			return "", err
+		}
+		return o.name, nil
+	}
+
+	// A hard link fails rather than replace a file created in the meantime
+	name := o.name
+	for n := 1; ; n++ {
+		err := os.Link(o.Name(), name)
+		if err == nil {
+			o.remove()
+			return name, nil
+		}
+		if !os.IsExist(err) {
+			// Some file systems have no hard links: fall back to a rename
+			if _, serr := os.Lstat(name); os.IsNotExist(serr) {
+				if err = os.Rename(o.Name(), name); err == nil {
+					return name, nil
+				}
+			}
+			o.remove()
+			return "", err
+		}
+		if o.opts.output != "" {
+			o.remove()
+			return "", errFileExists(o.name)
+		}
+
+		ext := filepath.Ext(o.name)
+		name = fmt.Sprintf("%s_%d%s", strings.TrimSuffix(o.name, ext), n, ext)
+	}
+}
+
+// abort closes the file and removes it, unless commit already ran. Files
+// written in place, such as those appended to, are left as they are, IPs
+// written so far included.
+func (o *outputFile) abort() {
+	if o.done {
+		return
+	}
+	o.done = true
+	o.Close()
+	o.remove()
+}
+
+// remove deletes the temporary file, if any.
+func (o *outputFile) remove() {
+	if !o.inPlace {
+		os.Remove(o.Name())
+	}
+}
+
+// dedupOwners returns, for each entry of deduped, the index of the entry of
+// inputs it was carved from. DedupCIDRs only ever removes the parts an earlier
+// entry already covers, so that is the first input containing the piece's
+// first address.
+func dedupOwners(inputs, deduped []string) ([]int, error) {
+	ranges := make([]addrRange, len(inputs))
+	for i, input := range inputs {
+		r, err := parseAddrRange(input)
+		if err != nil {
+			return nil, err
+		}
+		ranges[i] = r
+	}
+
+	owners := make([]int, len(deduped))
+	for i, piece := range deduped {
+		p, err := parseAddrRange(piece)
+		if err != nil {
+			return nil, err
+		}
+		for j, r := range ranges {
+			if r.contains(p.first) {
+				owners[i] = j
+				break
+			}
+		}
+	}
+
+	return owners, nil
+}
+
+// sourcedAddr is an IP held back for -sort or -shuffle, with the index of its
+// CIDR.
+type sourcedAddr struct {
+	addr   netip.Addr
+	source int
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer, columns []string, opts *options) ipWriter {
+	cw := csv.NewWriter(w)
+	if opts.comma != 0 {
+		cw.Comma = opts.comma
+	}
+
+	// Write errors are sticky in the underlying buffer and surface in Flush
+	if opts.header {
+		cw.Write(columns)
+	}
+
+	return &csvWriter{w: cw}
+}
+
+// parseDelimiter returns the single rune s stands for, accepting \t for a
+// tab, and rejects anything encoding/csv can't use as a field separator.
+func parseDelimiter(s string) (rune, error) {
+	if s == `\t` {
+		return '\t', nil
+	}
+
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 || size != len(s) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("invalid delimiter %q: must be a single character other than a quote or newline", s)
+	}
+
+	return r, nil
+}
+
+func (c *csvWriter) WriteRow(row []string) error {
+	return c.w.Write(row)
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// textWriter writes one row per line with fields separated by a space. It
+// never quotes anything, and every line, including the last, ends in "\n".
+type textWriter struct {
+	buf *bufio.Writer
+}
+
+func newTextWriter(w io.Writer, columns []string, opts *options) ipWriter {
+	return &textWriter{buf: bufio.NewWriter(w)}
+}
+
+func (t *textWriter) WriteRow(row []string) error {
+	for i, field := range row {
+		if i > 0 {
+			t.buf.WriteByte(' ')
+		}
+		t.buf.WriteString(field)
+	}
+
+	return t.buf.WriteByte('\n')
+}
+
+func (t *textWriter) Flush() error {
+	return t.buf.Flush()
+}
+
+// nmapWriter writes the bare IPs, one per line, as nmap -iL reads them best.
+// Unlike txt, it leaves out every other column, so whatever else is selected
+// the file stays a plain target list. Lines always end in a lone "\n" and
+// nothing precedes the first one, no header and no byte order mark, whatever
+// the platform.
+type nmapWriter struct {
+	buf *bufio.Writer
+	ip  int
+}
+
+func newNmapWriter(w io.Writer, columns []string, opts *options) ipWriter {
+	return &nmapWriter{buf: bufio.NewWriter(w), ip: slices.Index(columns, "ip")}
+}
+
+func (n *nmapWriter) WriteRow(row []string) error {
+	n.buf.WriteString(row[n.ip])
+	return n.buf.WriteByte('\n')
+}
+
+func (n *nmapWriter) Flush() error {
+	return n.buf.Flush()
+}
+
+// hostsWriter writes /etc/hosts-style lines pairing each IP with a name made
+// of a prefix and the address, with its dots or colons turned into dashes.
+// Any other column is left out, as hosts files have no place for it.
+type hostsWriter struct {
+	buf    *bufio.Writer
+	prefix string
+	ip     int
+}
+
+func newHostsWriter(w io.Writer, columns []string, opts *options) ipWriter {
+	return &hostsWriter{buf: bufio.NewWriter(w), prefix: opts.hostnamePrefix, ip: slices.Index(columns, "ip")}
+}
+
+func (h *hostsWriter) WriteRow(row []string) error {
+	ip := row[h.ip]
+	h.buf.WriteString(ip)
+	h.buf.WriteByte(' ')
+	h.buf.WriteString(hostname(h.prefix, ip))
+	return h.buf.WriteByte('\n')
+}
+
+func (h *hostsWriter) Flush() error {
+	return h.buf.Flush()
+}
+
+// hostname derives the host name of ip, such as host-10-0-0-5 for 10.0.0.5.
+// IPv6 addresses are written out in full, as in
+// host-2001-0db8-0000-0000-0000-0000-0000-0001, since the :: of their short
+// form would leave runs of dashes, or one at the end, which names can't have.
+func hostname(prefix, ip string) string {
+	if addr, err := netip.ParseAddr(ip); err == nil && addr.Is6() {
+		ip = addr.StringExpanded()
+	}
+
+	return prefix + strings.NewReplacer(".", "-", ":", "-").Replace(ip)
+}
+
+// inlineWriter writes every IP on a single line, separated by commas and
+// ended by one newline, for pasting into consoles that take a list that way.
+// Like hosts files, the line has no place for the other columns.
+type inlineWriter struct {
+	buf *bufio.Writer
+	ip  int
+	n   int
+}
+
+func newInlineWriter(w io.Writer, columns []string, opts *options) ipWriter {
+	return &inlineWriter{buf: bufio.NewWriter(w), ip: slices.Index(columns, "ip")}
+}
+
+func (l *inlineWriter) WriteRow(row []string) error {
+	if l.n > 0 {
+		l.buf.WriteByte(',')
+	}
+	l.n++
+
+	_, err := l.buf.WriteString(row[l.ip])
+	return err
+}
+
+func (l *inlineWriter) Flush() error {
+	if err := l.buf.WriteByte('\n'); err != nil {
+		return err
+	}
+
+	return l.buf.Flush()
+}
+
+// templateRow is what a -template is executed with for each IP: one field per
+// output column, empty unless the column is selected, and the position of the
+// IP in the output, counting from 0.
+type templateRow struct {
+	IP, CIDR, Prefix, Offset, Int, Hex, Mapped, Class, PTR string
+	Index                                                  int
+}
+
+// templateWriter writes a line per IP by executing the -template, compiled
+// once up front, with its templateRow. A newline follows each execution.
+type templateWriter struct {
+	buf     *bufio.Writer
+	tmpl    *template.Template
+	columns []string
+	n       int
+}
+
+func newTemplateWriter(w io.Writer, columns []string, opts *options) ipWriter {
+	return &templateWriter{buf: bufio.NewWriter(w), tmpl: opts.tmpl, columns: columns}
+}
+
+func (t *templateWriter) WriteRow(row []string) error {
+	data := templateRow{Index: t.n}
+	t.n++
+	for j, col := range t.columns {
+		switch col {
+		case "ip":
+			data.IP = row[j]
+		case "cidr":
+			data.CIDR = row[j]
+		case "prefix":
+			data.Prefix = row[j]
+		case "offset":
+			data.Offset = row[j]
+		case "int":
+			data.Int = row[j]
+		case "hex":
+			data.Hex = row[j]
+		case "mapped":
+			data.Mapped = row[j]
+		case "class":
+			data.Class = row[j]
+		case "ptr":
+			data.PTR = row[j]
+		}
+	}
+
+	if err := t.tmpl.Execute(t.buf, &data); err != nil {
+		return err
+	}
+	return t.buf.WriteByte('\n')
+}
+
+func (t *templateWriter) Flush() error {
+	return t.buf.Flush()
+}
+
+// parseTemplate compiles the -template text. It is tried on an empty row too,
+// so a mistake such as an unknown field fails the run before any file is
+// created rather than on the first IP.
+func parseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("template").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -template: %w", err)
+	}
+	if err := tmpl.Execute(io.Discard, &templateRow{}); err != nil {
+		return nil, fmt.Errorf("invalid -template: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+// templateColumns returns the columns whose templateRow fields tmpl refers
+// to, as .CIDR or $.CIDR, in the order of columnNames.
+func templateColumns(tmpl *template.Template) []string {
+	used := make(map[string]bool)
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.ChainNode:
+			walk(n.Node)
+		case *parse.FieldNode:
+			used[strings.ToLower(n.Ident[0])] = true
+		case *parse.VariableNode:
+			if len(n.Ident) > 1 && n.Ident[0] == "$" {
+				used[strings.ToLower(n.Ident[1])] = true
+			}
+		}
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			walk(t.Tree.Root)
+		}
+	}
+
+	var columns []string
+	for _, name := range columnNames {
+		if used[name] {
+			columns = append(columns, name)
+		}
+	}
+	return columns
+}
+
+// sqlWriter writes INSERT statements into table, one per line, each adding up
+// to batch rows so the file stays small and loads fast. Every selected column
+// becomes a column of the table, named after it and quoted, as offset and int
+// are keywords, and every value a quoted string literal, which PostgreSQL
+// casts to the column type, inet included.
+type sqlWriter struct {
+	buf    *bufio.Writer
+	insert string
+	batch  int
+	n      int
+}
+
+func newSQLWriter(w io.Writer, columns []string, opts *options) ipWriter {
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = sqlQuoteIdentifier(col)
+	}
+	insert := "INSERT INTO " + opts.table + " (" + strings.Join(names, ", ") + ") VALUES "
+	return &sqlWriter{buf: bufio.NewWriter(w), insert: insert, batch: opts.sqlBatch}
+}
+
+func (s *sqlWriter) WriteRow(row []string) error {
+	if s.n == 0 {
+		s.buf.WriteString(s.insert)
+	} else {
+		s.buf.WriteString(", ")
+	}
+	s.n++
+
+	s.buf.WriteByte('(')
+	for i, value := range row {
+		if i > 0 {
+			s.buf.WriteString(", ")
+		}
+		s.buf.WriteString(sqlQuote(value))
+	}
+	s.buf.WriteByte(')')
+
+	if s.n == s.batch {
+		return s.end()
+	}
+
+	return nil
+}
+
+// end terminates the statement being written, if any.
+func (s *sqlWriter) end() error {
+	if s.n == 0 {
+		return nil
+	}
+	s.n = 0
+
+	_, err := s.buf.WriteString(";\n")
+	return err
+}
+
+func (s *sqlWriter) Flush() error {
+	if err := s.end(); err != nil {
+		return err
+	}
+
+	return s.buf.Flush()
+}
+
+// sqlQuote returns value as an SQL string literal, doubling any quote in it.
+func sqlQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// sqlQuoteIdentifier returns name as a quoted SQL identifier, doubling any
+// double quote in it.
+func sqlQuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqlIdentifier matches the table names -table accepts: an unquoted SQL
+// identifier, optionally qualified by its schema, as in public.ips.
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}(\.[A-Za-z_][A-Za-z0-9_]{0,62})?$`)
+
+// checkTableName rejects any -table that would need quoting, so it can go into
+// the statements as is without ever escaping them.
+func checkTableName(table string) error {
+	if !sqlIdentifier.MatchString(table) {
+		return fmt.Errorf("invalid table name %q: use letters, digits and underscores, optionally as schema.table", table)
+	}
+
+	return nil
+}
+
+// jsonWriter writes a single JSON array, emitting each element as it arrives
+// instead of marshaling the whole list at once. With only the ip column the
+// elements are plain strings; otherwise each row becomes an object keyed by
+// column name.
+type jsonWriter struct {
+	buf     *bufio.Writer
+	columns []string
+	n       int
+}
+
+func newJSONWriter(w io.Writer, columns []string, opts *options) ipWriter {
+	return &jsonWriter{buf: bufio.NewWriter(w), columns: columns}
+}
+
+func (j *jsonWriter) WriteRow(row []string) error {
+	sep := ","
+	if j.n == 0 {
+		sep = "["
+	}
+	j.n++
+
+	if _, err := j.buf.WriteString(sep); err != nil {
+		return err
+	}
+
+	if len(j.columns) == 1 {
+		return writeJSONString(j.buf, row[0])
+	}
+
+	return writeJSONObject(j.buf, j.columns, row)
+}
+
+func (j *jsonWriter) Flush() error {
+	end := "]\n"
+	if j.n == 0 {
+		end = "[]\n"
+	}
+
+	if _, err := j.buf.WriteString(end); err != nil {
+		return err
+	}
+
+	return j.buf.Flush()
+}
+
+// ndjsonWriter writes one JSON object per line, keyed by column name, so each
+// line can be parsed on its own.
+type ndjsonWriter struct {
+	buf     *bufio.Writer
+	columns []string
+}
+
+func newNDJSONWriter(w io.Writer, columns []string, opts *options) ipWriter {
+	return &ndjsonWriter{buf: bufio.NewWriter(w), columns: columns}
+}
+
+func (j *ndjsonWriter) WriteRow(row []string) error {
+	if err := writeJSONObject(j.buf, j.columns, row); err != nil {
+		return err
+	}
+
+	return j.buf.WriteByte('\n')
+}
+
+func (j *ndjsonWriter) Flush() error {
+	return j.buf.Flush()
+}
+
+// writeJSONObject writes row as a JSON object keyed by columns, keeping the
+// column order instead of the sorted order json.Marshal uses for maps.
+func writeJSONObject(w *bufio.Writer, columns, row []string) error {
+	w.WriteByte('{')
+	for i, col := range columns {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		if err := writeJSONString(w, col); err != nil {
+			return err
+		}
+		w.WriteByte(':')
+		if err := writeJSONString(w, row[i]); err != nil {
+			return err
+		}
+	}
+
+	return w.WriteByte('}')
+}
+
+func writeJSONString(w *bufio.Writer, s string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
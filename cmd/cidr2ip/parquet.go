@@ -0,0 +1,312 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// parquetGroupRows is how many rows are held in memory before they are
+// written out as a row group, which keeps the writer streaming.
+const parquetGroupRows = 1 << 16
+
+const parquetMagic = "PAR1"
+
+// Parquet enum values used in the file metadata.
+const (
+	parquetInt64     = 2 // Type
+	parquetByteArray = 6 // Type
+	parquetRequired  = 0 // FieldRepetitionType
+	parquetUTF8      = 0 // ConvertedType
+	parquetPlain     = 0 // Encoding
+	parquetRLE       = 3 // Encoding
+	parquetDataPage  = 0 // PageType
+)
+
+// parquetWriter writes an Apache Parquet file with one required column per
+// output column: the int column as INT64 and every other one as a UTF-8
+// string. Values are PLAIN-encoded and uncompressed, one page per column in
+// each row group. The file metadata goes at the end, so Flush must be called
+// for the file to be readable.
+type parquetWriter struct {
+	buf     *bufio.Writer
+	offset  int64
+	columns []parquetColumn
+	ip      int
+	rows    int
+	groups  []parquetRowGroup
+}
+
+type parquetColumn struct {
+	name  string
+	isInt bool
+	data  bytes.Buffer
+}
+
+type parquetRowGroup struct {
+	rows   int
+	chunks []parquetChunk
+}
+
+// parquetChunk locates the single page of a column within a row group.
+type parquetChunk struct {
+	offset, size int64
+}
+
+func newParquetWriter(w io.Writer, columns []string, opts *options) ipWriter {
+	p := &parquetWriter{buf: bufio.NewWriter(w), ip: slices.Index(columns, "ip")}
+	for _, col := range columns {
+		p.columns = append(p.columns, parquetColumn{name: col, isInt: col == "int"})
+	}
+
+	// Write errors are sticky in the buffer and surface in WriteRow or Flush
+	p.write([]byte(parquetMagic))
+	return p
+}
+
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.buf.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+func (p *parquetWriter) WriteRow(row []string) error {
+	for i := range p.columns {
+		col := &p.columns[i]
+		if col.isInt {
+			// Only IPv4 addresses are sure to fit in an INT64
+			if strings.Contains(row[p.ip], ":") {
+				return fmt.Errorf("the parquet int column can't hold the IPv6 address %s", row[p.ip])
+			}
+			v, err := strconv.ParseInt(row[i], 10, 64)
+			if err != nil {
+				return err
+			}
+			binary.Write(&col.data, binary.LittleEndian, v)
+			continue
+		}
+		binary.Write(&col.data, binary.LittleEndian, uint32(len(row[i])))
+		col.data.WriteString(row[i])
+	}
+
+	if p.rows++; p.rows == parquetGroupRows {
+		return p.writeRowGroup()
+	}
+	return nil
+}
+
+// writeRowGroup writes the rows held so far as a row group.
+func (p *parquetWriter) writeRowGroup() error {
+	group := parquetRowGroup{rows: p.rows}
+	for i := range p.columns {
+		col := &p.columns[i]
+
+		var e compactEncoder
+		e.i32Field(1, parquetDataPage)
+		e.i32Field(2, int32(col.data.Len()))
+		e.i32Field(3, int32(col.data.Len()))
+		e.structField(5)
+		e.i32Field(1, int32(p.rows))
+		e.i32Field(2, parquetPlain)
+		e.i32Field(3, parquetRLE)
+		e.i32Field(4, parquetRLE)
+		e.endStruct()
+		e.b.WriteByte(0)
+
+		chunk := parquetChunk{offset: p.offset, size: int64(e.b.Len() + col.data.Len())}
+		if err := p.write(e.b.Bytes()); err != nil {
+			return err
+		}
+		if err := p.write(col.data.Bytes()); err != nil {
+			return err
+		}
+		col.data.Reset()
+		group.chunks = append(group.chunks, chunk)
+	}
+
+	p.groups = append(p.groups, group)
+	p.rows = 0
+	return nil
+}
+
+func (p *parquetWriter) Flush() error {
+	if p.rows > 0 {
+		if err := p.writeRowGroup(); err != nil {
+			return err
+		}
+	}
+
+	footer := p.footer()
+	if err := p.write(footer); err != nil {
+		return err
+	}
+	binary.Write(p.buf, binary.LittleEndian, uint32(len(footer)))
+	p.buf.WriteString(parquetMagic)
+
+	return p.buf.Flush()
+}
+
+// footer returns the FileMetaData of the file, Thrift-encoded.
+func (p *parquetWriter) footer() []byte {
+	var e compactEncoder
+	total := 0
+	for _, g := range p.groups {
+		total += g.rows
+	}
+
+	e.i32Field(1, 1)
+	e.listField(2, ctStruct, len(p.columns)+1)
+	e.beginStruct()
+	e.stringField(4, "schema")
+	e.i32Field(5, int32(len(p.columns)))
+	e.endStruct()
+	for _, col := range p.columns {
+		e.beginStruct()
+		e.i32Field(1, col.physicalType())
+		e.i32Field(3, parquetRequired)
+		e.stringField(4, col.name)
+		if !col.isInt {
+			e.i32Field(6, parquetUTF8)
+			// LogicalType is a union; field 1 is an empty StringType
+			e.structField(10)
+			e.structField(1)
+			e.endStruct()
+			e.endStruct()
+		}
+		e.endStruct()
+	}
+	e.i64Field(3, int64(total))
+
+	e.listField(4, ctStruct, len(p.groups))
+	for _, g := range p.groups {
+		var size int64
+		e.beginStruct()
+		e.listField(1, ctStruct, len(g.chunks))
+		for i, chunk := range g.chunks {
+			col := p.columns[i]
+			size += chunk.size
+
+			e.beginStruct()
+			e.i64Field(2, chunk.offset)
+			e.structField(3)
+			e.i32Field(1, col.physicalType())
+			e.listField(2, ctI32, 1)
+			e.varint(zigzag(parquetPlain))
+			e.listField(3, ctBinary, 1)
+			e.binary(col.name)
+			e.i32Field(4, 0) // UNCOMPRESSED
+			e.i64Field(5, int64(g.rows))
+			e.i64Field(6, chunk.size)
+			e.i64Field(7, chunk.size)
+			e.i64Field(9, chunk.offset)
+			e.endStruct()
+			e.endStruct()
+		}
+		e.i64Field(2, size)
+		e.i64Field(3, int64(g.rows))
+		e.endStruct()
+	}
+	e.stringField(6, app+" version "+version)
+	e.b.WriteByte(0)
+
+	return e.b.Bytes()
+}
+
+func (c parquetColumn) physicalType() int32 {
+	if c.isInt {
+		return parquetInt64
+	}
+	return parquetByteArray
+}
+
+// Thrift compact protocol field types.
+const (
+	ctI32    = 5
+	ctI64    = 6
+	ctBinary = 8
+	ctList   = 9
+	ctStruct = 12
+)
+
+// compactEncoder writes the Thrift compact protocol, which Parquet uses for
+// its page headers and file metadata. It only covers the types those need.
+type compactEncoder struct {
+	b      bytes.Buffer
+	lastID int16
+	stack  []int16
+}
+
+func (e *compactEncoder) field(id int16, typ byte) {
+	if delta := id - e.lastID; delta > 0 && delta <= 15 {
+		e.b.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		e.b.WriteByte(typ)
+		e.varint(zigzag(int64(id)))
+	}
+	e.lastID = id
+}
+
+func (e *compactEncoder) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.b.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func (e *compactEncoder) i32Field(id int16, v int32) {
+	e.field(id, ctI32)
+	e.varint(zigzag(int64(v)))
+}
+
+func (e *compactEncoder) i64Field(id int16, v int64) {
+	e.field(id, ctI64)
+	e.varint(zigzag(v))
+}
+
+func (e *compactEncoder) binary(s string) {
+	e.varint(uint64(len(s)))
+	e.b.WriteString(s)
+}
+
+func (e *compactEncoder) stringField(id int16, s string) {
+	e.field(id, ctBinary)
+	e.binary(s)
+}
+
+// listField starts a list of n elements of type elem. Struct elements are
+// then written each between beginStruct and endStruct.
+func (e *compactEncoder) listField(id int16, elem byte, n int) {
+	e.field(id, ctList)
+	if n < 15 {
+		e.b.WriteByte(byte(n)<<4 | elem)
+		return
+	}
+	e.b.WriteByte(0xf0 | elem)
+	e.varint(uint64(n))
+}
+
+func (e *compactEncoder) structField(id int16) {
+	e.field(id, ctStruct)
+	e.beginStruct()
+}
+
+func (e *compactEncoder) beginStruct() {
+	e.stack = append(e.stack, e.lastID)
+	e.lastID = 0
+}
+
+func (e *compactEncoder) endStruct() {
+	e.b.WriteByte(0)
+	e.lastID = e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestParquetFormat(t *testing.T) {
+	buildBinary(t)
+
+	// Test reading back a file with a string and an int64 column
+	file := "ips.parquet"
+	if _, err := runCommand(binPath, "-format", "parquet", "-int", "-o", file, "10.0.0.0/31", "192.168.1.255"); err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	columns, rows := readParquet(t, data)
+
+	expected := "[ip int] [[10.0.0.0 167772160] [10.0.0.1 167772161] [192.168.1.255 3232236031]]"
+	if got := fmt.Sprint(columns, rows); got != expected {
+		t.Errorf("Expected %s, got %s instead.", expected, got)
+	}
+
+	// Test that the default file name ends in .parquet
+	output, err := runCommand(binPath, "-format", "parquet", "10.0.0.0/30")
+	if err != nil {
+		t.Fatalf("Command failed with error: %v", err)
+	}
+	name := extractFileName(output, `IP list saved to (cidr2ip_\S+\.parquet)`)
+
+	// Test that IPv6 addresses, too large for an int64, are refused
+	output, err = runCommand(binPath, "-format", "parquet", "-int", "-o", "-", "2001:db8::/127")
+	if err == nil || !strings.Contains(output, "IPv6") {
+		t.Errorf("Expected an IPv6 error, got %q instead.", output)
+	}
+
+	removeFiles(t, file, name)
+}
+
+func TestParquetRowGroups(t *testing.T) {
+	var buf bytes.Buffer
+	w := newParquetWriter(&buf, []string{"ip", "cidr"}, &options{})
+
+	// Test that rows spanning several row groups all come back in order
+	n := parquetGroupRows + 10
+	for i := 0; i < n; i++ {
+		if err := w.WriteRow([]string{fmt.Sprint(i), "x"}); err != nil {
+			t.Fatalf("WriteRow failed with error: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed with error: %v", err)
+	}
+
+	_, rows := readParquet(t, buf.Bytes())
+	if len(rows) != n {
+		t.Fatalf("Expected %d rows, got %d instead.", n, len(rows))
+	}
+	for i, row := range rows {
+		if row[0] != fmt.Sprint(i) || row[1] != "x" {
+			t.Fatalf("Row %d: expected [%d x], got %v instead.", i, i, row)
+		}
+	}
+
+	// Test that an empty file is still valid
+	buf.Reset()
+	if err := newParquetWriter(&buf, []string{"ip"}, &options{}).Flush(); err != nil {
+		t.Fatalf("Flush failed with error: %v", err)
+	}
+	if _, rows := readParquet(t, buf.Bytes()); len(rows) != 0 {
+		t.Errorf("Expected no rows, got %v instead.", rows)
+	}
+}
+
+// TestParquetGolden checks the writer against files in testdata that were
+// read back with an independent reader, github.com/parquet-go/parquet-go
+// v0.32.0, so a change to the layout shows up even if readParquet agrees
+// with it. Regenerate the files with the commands below and check them with
+// a Parquet reader again when the writer or version changes.
+func TestParquetGolden(t *testing.T) {
+	buildBinary(t)
+
+	tests := []struct {
+		golden string
+		args   []string
+	}{
+		// cidr2ip -format parquet -columns ip,cidr,int -o testdata/ips.parquet 10.0.0.0/31 192.168.1.255
+		{"testdata/ips.parquet", []string{"-columns", "ip,cidr,int", "10.0.0.0/31", "192.168.1.255"}},
+		// cidr2ip -format parquet -exclude 10.0.0.0/31 -o testdata/empty.parquet 10.0.0.0/31
+		{"testdata/empty.parquet", []string{"-exclude", "10.0.0.0/31", "10.0.0.0/31"}},
+	}
+
+	for _, test := range tests {
+		args := append([]string{"-format", "parquet", "-o", "-"}, test.args...)
+		cmd := exec.Command(binPath, args...)
+		got, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("Command failed with error: %v", err)
+		}
+
+		expected, err := os.ReadFile(test.golden)
+		if err != nil {
+			t.Fatalf("Failed to read golden file: %v", err)
+		}
+		if !bytes.Equal(got, expected) {
+			t.Errorf("%s: output differs from the golden file.\nExpected: %q\nGot:      %q", test.golden, expected, got)
+		}
+	}
+
+	removeFiles(t)
+}
+
+// readParquet decodes the flat, PLAIN-encoded and uncompressed Parquet files
+// parquetWriter writes, returning the column names and the rows as strings.
+func readParquet(t *testing.T, data []byte) ([]string, [][]string) {
+	t.Helper()
+
+	if len(data) < 12 || string(data[:4]) != parquetMagic || string(data[len(data)-4:]) != parquetMagic {
+		t.Fatalf("Not a parquet file: %q", data)
+	}
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	meta := (&thriftDecoder{b: data, pos: len(data) - 8 - size}).readStruct()
+
+	var (
+		columns []string
+		types   []int64
+	)
+	for _, el := range meta[2].([]interface{})[1:] {
+		schema := el.(map[int16]interface{})
+		columns = append(columns, schema[4].(string))
+		types = append(types, schema[1].(int64))
+	}
+
+	var rows [][]string
+	for _, el := range meta[4].([]interface{}) {
+		group := el.(map[int16]interface{})
+		start := len(rows)
+		for j, c := range group[1].([]interface{}) {
+			md := c.(map[int16]interface{})[3].(map[int16]interface{})
+			d := &thriftDecoder{b: data, pos: int(md[9].(int64))}
+			header := d.readStruct()
+			page := bytes.NewReader(data[d.pos : d.pos+int(header[3].(int64))])
+			n := int(header[5].(map[int16]interface{})[1].(int64))
+
+			for k := 0; k < n; k++ {
+				if j == 0 {
+					rows = append(rows, make([]string, len(columns)))
+				}
+				if types[j] == parquetInt64 {
+					var v int64
+					binary.Read(page, binary.LittleEndian, &v)
+					rows[start+k][j] = fmt.Sprint(v)
+					continue
+				}
+				var l uint32
+				binary.Read(page, binary.LittleEndian, &l)
+				s := make([]byte, l)
+				page.Read(s)
+				rows[start+k][j] = string(s)
+			}
+		}
+	}
+
+	return columns, rows
+}
+
+// thriftDecoder reads the Thrift compact protocol into generic values:
+// structs as maps keyed by field id, lists as slices, integers as int64 and
+// binaries as strings.
+type thriftDecoder struct {
+	b   []byte
+	pos int
+}
+
+func (d *thriftDecoder) varint() uint64 {
+	v, n := binary.Uvarint(d.b[d.pos:])
+	d.pos += n
+	return v
+}
+
+func (d *thriftDecoder) zigzag() int64 {
+	v := d.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (d *thriftDecoder) readStruct() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var id int16
+	for {
+		h := d.b[d.pos]
+		d.pos++
+		if h == 0 {
+			return fields
+		}
+		if delta := int16(h >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(d.zigzag())
+		}
+		fields[id] = d.readValue(h & 0x0f)
+	}
+}
+
+func (d *thriftDecoder) readValue(typ byte) interface{} {
+	switch typ {
+	case 1, 2:
+		return typ == 1
+	case ctI32, ctI64:
+		return d.zigzag()
+	case ctBinary:
+		n := int(d.varint())
+		d.pos += n
+		return string(d.b[d.pos-n : d.pos])
+	case ctList:
+		h := d.b[d.pos]
+		d.pos++
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(d.varint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = d.readValue(h & 0x0f)
+		}
+		return list
+	case ctStruct:
+		return d.readStruct()
+	}
+
+	panic(fmt.Sprintf("unsupported thrift type %d", typ))
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often the progress line is redrawn.
+const progressInterval = 250 * time.Millisecond
+
+// progress redraws a single status line on a terminal while IPs are being
+// generated. The count is updated on the hot path with a single atomic add;
+// all formatting happens on a ticker so large runs don't flood the terminal.
+type progress struct {
+	w     io.Writer
+	color bool   // whether w is stderr, whose colors colorize decides
+	total uint64 // IPs expected, or 0 if unknown or too many to count
+	start time.Time
+	width int // length of the line last drawn, to blank out what it leaves
+	count atomic.Uint64
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// newProgress returns the progress of generating total IPs, to be drawn to w.
+// A total too large for the count itself, as huge IPv6 blocks have, leaves
+// out the percentage and the ETA, along with a nil or zero total.
+func newProgress(w io.Writer, total *big.Int) *progress {
+	p := &progress{w: w, color: w == io.Writer(os.Stderr), start: time.Now(), done: make(chan struct{})}
+	if total != nil && total.IsUint64() {
+		p.total = total.Uint64()
+	}
+
+	return p
+}
+
+// startProgress starts drawing the progress of generating total IPs to w.
+func startProgress(w io.Writer, total *big.Int) *progress {
+	p := newProgress(w, total)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.draw(p.line(false))
+			case <-p.done:
+				return
+			}
+		}
+	}()
+
+	return p
+}
+
+func (p *progress) add() {
+	p.count.Add(1)
+}
+
+// stop draws the final state and ends the progress line.
+func (p *progress) stop() {
+	close(p.done)
+	p.wg.Wait()
+	p.draw(p.line(true))
+	fmt.Fprintln(p.w)
+}
+
+// draw replaces the line last drawn with line. Whatever is left of a longer
+// one, such as the ETA the final line goes without, is blanked out first.
+func (p *progress) draw(line string) {
+	if len(line) < p.width {
+		fmt.Fprintf(p.w, "\r%s", strings.Repeat(" ", p.width))
+	}
+	p.width = len(line)
+	fmt.Fprintf(p.w, "\r%s", line)
+}
+
+// line returns the progress line. Until the final one, it estimates the time
+// left from the throughput so far.
+func (p *progress) line(final bool) string {
+	n := p.count.Load()
+	count := fmt.Sprint(n)
+	if p.color {
+		count = colorize(count, colorCyan)
+	}
+	if p.total == 0 {
+		return fmt.Sprintf("%s IPs generated", count)
+	}
+
+	pct := float64(n) * 100 / float64(p.total)
+	elapsed := time.Since(p.start)
+	if final || n == 0 || n >= p.total || elapsed <= 0 {
+		return fmt.Sprintf("%s IPs generated (%.1f%%)", count, pct)
+	}
+
+	left := time.Duration(float64(elapsed) * float64(p.total-n) / float64(n))
+	return fmt.Sprintf("%s IPs generated (%.1f%%, ETA %s)", count, pct, left.Round(time.Second))
+}
+
+// isTerminal reports whether f is attached to an interactive terminal.
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return stat.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// resolveWorkers is the number of reverse DNS lookups run concurrently.
+	resolveWorkers = 32
+
+	// resolveBatch is the number of rows buffered before their lookups start.
+	resolveBatch = 4 * resolveWorkers
+)
+
+// resolver is an ipWriter that fills in the ptr column of each row with the
+// PTR name of the row's IP before forwarding the row to the wrapped writer.
+// Rows are resolved concurrently in small batches, so they still reach the
+// wrapped writer in input order.
+type resolver struct {
+	w       ipWriter
+	timeout time.Duration
+	ip, ptr int
+	rows    [][]string
+}
+
+// newResolver returns a resolver looking up the IP in column ip of each row
+// and writing its name to column ptr.
+func newResolver(w ipWriter, timeout time.Duration, ip, ptr int) *resolver {
+	return &resolver{w: w, timeout: timeout, ip: ip, ptr: ptr}
+}
+
+func (r *resolver) WriteRow(row []string) error {
+	r.rows = append(r.rows, row)
+	if len(r.rows) < resolveBatch {
+		return nil
+	}
+
+	return r.resolveRows()
+}
+
+func (r *resolver) Flush() error {
+	if err := r.resolveRows(); err != nil {
+		return err
+	}
+
+	return r.w.Flush()
+}
+
+func (r *resolver) resolveRows() error {
+	names := make([]string, len(r.rows))
+	sem := make(chan struct{}, resolveWorkers)
+	var wg sync.WaitGroup
+
+	for i, row := range r.rows {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, ip string) {
+			defer wg.Done()
+			names[i] = r.lookup(ip)
+			<-sem
+		}(i, row[r.ip])
+	}
+
+	wg.Wait()
+
+	for i, row := range r.rows {
+		row[r.ptr] = names[i]
+		if err := r.w.WriteRow(row); err != nil {
+			return err
+		}
+	}
+
+	r.rows = r.rows[:0]
+	return nil
+}
+
+// lookup returns the first PTR name of ip, or an empty string if it has none
+// or the lookup fails.
+func (r *resolver) lookup(ip string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+
+	return strings.TrimSuffix(names[0], ".")
+}
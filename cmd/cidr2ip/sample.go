@@ -0,0 +1,97 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package main
+
+import (
+	"math/big"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/rcmelendez/cidr2ip"
+)
+
+// sampler picks k distinct addresses uniformly at random from each CIDR. It
+// draws offsets into the range instead of expanding it, so sampling a huge
+// block costs no more than sampling a small one. Unless quiet, it warns about
+// each CIDR with fewer than k addresses, which are all written instead.
+type sampler struct {
+	k     int
+	rng   *rand.Rand
+	quiet bool
+}
+
+func newSampler(k int, seed int64, quiet bool) *sampler {
+	return &sampler{k: k, rng: newRand(seed), quiet: quiet}
+}
+
+// newRand returns the random source of -sample and -shuffle, seeded with
+// -seed, or with the current time if that is 0.
+func newRand(seed int64) *rand.Rand {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	return rand.New(rand.NewSource(seed))
+}
+
+// each is an eachFunc that walks the sampled addresses in ascending order.
+func (s *sampler) each(cidr string, fn func(addr netip.Addr) error) error {
+	first, _, err := cidr2ip.ParseRange(cidr)
+	if err != nil {
+		return err
+	}
+
+	size, err := cidr2ip.CountCIDR(cidr)
+	if err != nil {
+		return err
+	}
+
+	if size.Cmp(big.NewInt(int64(s.k))) <= 0 {
+		if size.Cmp(big.NewInt(int64(s.k))) < 0 && !s.quiet {
+			warnf("-sample %d exceeds the %s IPs in %s, writing all of them", s.k, size, cidr)
+		}
+		return cidr2ip.EachAddr(cidr, fn)
+	}
+
+	for _, offset := range s.offsets(size) {
+		if err := fn(ipAtOffset(first, offset)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// offsets returns k distinct offsets in [0, size) in ascending order, using
+// Floyd's algorithm so only k values are ever held in memory.
+func (s *sampler) offsets(size *big.Int) []*big.Int {
+	seen := make(map[string]bool, s.k)
+	picked := make([]*big.Int, 0, s.k)
+
+	j := new(big.Int).Sub(size, big.NewInt(int64(s.k)))
+	for ; j.Cmp(size) < 0; j.Add(j, big.NewInt(1)) {
+		t := new(big.Int).Rand(s.rng, new(big.Int).Add(j, big.NewInt(1)))
+		if seen[t.String()] {
+			t.Set(j)
+		}
+		seen[t.String()] = true
+		picked = append(picked, t)
+	}
+
+	sort.Slice(picked, func(a, b int) bool {
+		return picked[a].Cmp(picked[b]) < 0
+	})
+
+	return picked
+}
+
+// ipAtOffset returns the address n positions after first.
+func ipAtOffset(first net.IP, n *big.Int) netip.Addr {
+	sum := new(big.Int).Add(new(big.Int).SetBytes(first), n)
+	addr, _ := netip.AddrFromSlice(sum.FillBytes(make([]byte, len(first))))
+	return addr
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rcmelendez/cidr2ip"
+)
+
+// runStats collects the figures printed by -stats while the IPs are written.
+type runStats struct {
+	start      time.Time
+	cidrs      int
+	ips        uint64
+	duplicates uint64
+}
+
+func (s *runStats) print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "CIDRs processed:\t%d\n", s.cidrs)
+	fmt.Fprintf(tw, "IPs generated:\t%d\n", s.ips)
+	fmt.Fprintf(tw, "Duplicate IPs:\t%d\n", s.duplicates)
+	fmt.Fprintf(tw, "Elapsed time:\t%s\n", time.Since(s.start).Round(time.Millisecond))
+	return tw.Flush()
+}
+
+// runSummary is the -json-summary report of a run, for scripts to parse
+// instead of the human-readable messages.
+type runSummary struct {
+	Inputs   int      `json:"inputs"`
+	CIDRs    int      `json:"cidrs"`
+	IPs      uint64   `json:"ips"`
+	Outputs  []string `json:"outputs"`
+	Duration float64  `json:"duration_seconds"`
+	Errors   []string `json:"errors"`
+}
+
+// writeSummary writes the -json-summary report of a run to file, or to stderr
+// if that is "-". The run read inputs entries, st holds its figures and files
+// the outputs it wrote. Every entry of invalid is listed among the errors,
+// followed by err, the error that ended the run, if any.
+func writeSummary(file string, inputs int, st *runStats, files []string, invalid []error, err error) error {
+	summary := runSummary{
+		Inputs:   inputs,
+		CIDRs:    st.cidrs,
+		IPs:      st.ips,
+		Outputs:  []string{},
+		Duration: time.Since(st.start).Seconds(),
+		Errors:   []string{},
+	}
+	for _, f := range files {
+		if isStdout(f) {
+			f = "stdout"
+		}
+		summary.Outputs = append(summary.Outputs, f)
+	}
+	for _, e := range invalid {
+		summary.Errors = append(summary.Errors, e.Error())
+	}
+	if err != nil {
+		summary.Errors = append(summary.Errors, err.Error())
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if file == "-" {
+		_, err = os.Stderr.Write(data)
+		return err
+	}
+
+	return os.WriteFile(file, data, 0644)
+}
+
+// dupChecker tells whether an IP of a CIDR was already covered by an earlier
+// one. It only keeps the ranges each CIDR adds on its own, so it costs the
+// same whatever the size of the blocks.
+type dupChecker struct {
+	owned [][]addrRange
+}
+
+func newDupChecker(cidrs []string) (*dupChecker, error) {
+	deduped, err := cidr2ip.DedupCIDRs(cidrs)
+	if err != nil {
+		return nil, err
+	}
+
+	owners, err := dedupOwners(cidrs, deduped)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &dupChecker{owned: make([][]addrRange, len(cidrs))}
+	for i, piece := range deduped {
+		r, err := parseAddrRange(piece)
+		if err != nil {
+			return nil, err
+		}
+		d.owned[owners[i]] = append(d.owned[owners[i]], r)
+	}
+
+	return d, nil
+}
+
+// isDup reports whether addr, which comes from cidrs[i], was already covered
+// by one of the CIDRs before it.
+func (d *dupChecker) isDup(i int, addr netip.Addr) bool {
+	for _, r := range d.owned[i] {
+		if r.contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// cidrLogger prints, for -V, how many IPs each CIDR produced and how long its
+// expansion took, followed by the totals of the run. The counts are those of
+// the selected IPs, before -exclude and the other filters drop any.
+type cidrLogger struct {
+	log   *log.Logger
+	start time.Time
+
+	mu    sync.Mutex
+	cidrs int
+	ips   uint64
+}
+
+func newCIDRLogger(w io.Writer, start time.Time) *cidrLogger {
+	return &cidrLogger{log: log.New(w, app+": ", 0), start: start}
+}
+
+// wrap returns an eachFunc that logs every CIDR each walks. With -j above 1
+// the CIDRs are expanded concurrently, so their lines can come out of order
+// and their timings include the wait for the writer to catch up.
+func (l *cidrLogger) wrap(each eachFunc) eachFunc {
+	return func(cidr string, fn func(addr netip.Addr) error) error {
+		var n uint64
+		start := time.Now()
+		err := each(cidr, func(addr netip.Addr) error {
+			n++
+			return fn(addr)
+		})
+		l.log.Printf("%s: %d IPs in %s", cidr, n, time.Since(start).Round(time.Microsecond))
+
+		l.mu.Lock()
+		l.cidrs++
+		l.ips += n
+		l.mu.Unlock()
+		return err
+	}
+}
+
+// total logs the figures of every CIDR logged so far.
+func (l *cidrLogger) total() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.log.Printf("total: %d IPs from %d CIDR(s) in %s", l.ips, l.cidrs, time.Since(l.start).Round(time.Microsecond))
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package cidr2ip
+
+import (
+	"math/big"
+	"net/netip"
+	"strings"
+)
+
+// CountCIDR returns the number of addresses covered by cidr, any notation
+// ParseRange accepts, without enumerating them. It is computed from the bounds
+// alone, so even a whole IPv6 /0 is counted at once; a big.Int holds the
+// result because IPv6 blocks overflow any integer type.
+func CountCIDR(cidr string) (*big.Int, error) {
+	first, last, err := parseAddrRange(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	count := new(big.Int).SetBytes(last.AsSlice())
+	count.Sub(count, new(big.Int).SetBytes(first.AsSlice()))
+	return count.Add(count, big.NewInt(1)), nil
+}
+
+// CountCIDRs returns the sum of the addresses covered by each entry of cidrs,
+// as CountCIDR counts them. Overlapping entries are counted as many times as
+// they appear; merge them with MergeCIDRs first to count distinct addresses.
+// An invalid entry is reported as a *ParseError with its position in cidrs as
+// the Line.
+func CountCIDRs(cidrs []string) (*big.Int, error) {
+	total := new(big.Int)
+	for i, cidr := range cidrs {
+		count, err := CountCIDR(cidr)
+		if err != nil {
+			return nil, atLine(err, i)
+		}
+		total.Add(total, count)
+	}
+
+	return total, nil
+}
+
+// UsableCount returns the number of addresses of cidr that can be assigned to
+// hosts. An IPv4 CIDR loses its network and broadcast addresses, except for a
+// /31, whose two addresses are both usable on a point-to-point link (RFC 3021),
+// and a /32, a single host. IPv6 has no broadcast, and ranges and bare IPs list
+// hosts already, so for those every address counts.
+func UsableCount(cidr string) (*big.Int, error) {
+	count, err := CountCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(cidr, "/") || strings.Contains(cidr, "-") {
+		return count, nil
+	}
+
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+	if err != nil {
+		return nil, &ParseError{CIDR: cidr, Err: err}
+	}
+	if prefix.Addr().Unmap().Is4() && count.Cmp(big.NewInt(2)) > 0 {
+		count.Sub(count, big.NewInt(2))
+	}
+
+	return count, nil
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package cidr2ip
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestCountCIDR(t *testing.T) {
+	tests := []struct {
+		cidr     string
+		expected *big.Int
+	}{
+		{"192.168.1.0/24", big.NewInt(256)},
+		{"10.0.0.0/30", big.NewInt(4)},
+		{"10.0.0.7/32", big.NewInt(1)},
+		{"10.0.0.1", big.NewInt(1)},
+		{"10.0.0.10-10.0.0.19", big.NewInt(10)},
+		{"10.0.0.0+100", big.NewInt(100)},
+		{"2001:db8::/64", new(big.Int).Lsh(big.NewInt(1), 64)},
+		{"::/0", new(big.Int).Lsh(big.NewInt(1), 128)},
+	}
+
+	for _, tt := range tests {
+		count, err := CountCIDR(tt.cidr)
+		if err != nil {
+			t.Fatalf("CountCIDR(%s) failed with error: %v", tt.cidr, err)
+		}
+		if count.Cmp(tt.expected) != 0 {
+			t.Errorf("CountCIDR(%s): expected %s, got %s instead.", tt.cidr, tt.expected, count)
+		}
+	}
+
+	var pe *ParseError
+	if _, err := CountCIDR("10.0.0.0/33"); !errors.As(err, &pe) {
+		t.Errorf("Expected a *ParseError, got %v instead.", err)
+	}
+}
+
+func TestCountCIDRs(t *testing.T) {
+	// Test that overlapping entries count every time they appear
+	count, err := CountCIDRs([]string{"10.0.0.0/24", "10.0.0.0/30", "2001:db8::/64"})
+	if err != nil {
+		t.Fatalf("CountCIDRs failed with error: %v", err)
+	}
+	expected := new(big.Int).Lsh(big.NewInt(1), 64)
+	expected.Add(expected, big.NewInt(260))
+	if count.Cmp(expected) != 0 {
+		t.Errorf("Expected %s, got %s instead.", expected, count)
+	}
+
+	// Test that an empty list counts nothing
+	if count, err := CountCIDRs(nil); err != nil || count.Sign() != 0 {
+		t.Errorf("Expected 0, got %v (%v) instead.", count, err)
+	}
+
+	// Test that an invalid entry is reported with its position
+	var pe *ParseError
+	_, err = CountCIDRs([]string{"10.0.0.0/24", "bogus"})
+	if !errors.As(err, &pe) || pe.Line != 2 {
+		t.Errorf("Expected a *ParseError on line 2, got %v instead.", err)
+	}
+}
+
+func TestUsableCount(t *testing.T) {
+	tests := []struct {
+		cidr     string
+		expected string
+	}{
+		{"192.168.1.0/24", "254"},
+		{"10.0.0.0/8", "16777214"},
+		{"192.168.0.0/30", "2"},
+		{"192.168.0.0/31", "2"},
+		{"192.168.0.1/32", "1"},
+		{"::ffff:192.168.0.0/120", "254"},
+		{"192.168.0.1", "1"},
+		{"192.168.0.0-192.168.0.3", "4"},
+		{"2001:db8::/64", "18446744073709551616"},
+	}
+
+	for _, tt := range tests {
+		count, err := UsableCount(tt.cidr)
+		if err != nil {
+			t.Fatalf("UsableCount(%s) failed with error: %v", tt.cidr, err)
+		}
+		if count.String() != tt.expected {
+			t.Errorf("UsableCount(%s): expected %s, got %s instead.", tt.cidr, tt.expected, count)
+		}
+	}
+
+	if _, err := UsableCount("10.0.0.0/33"); err == nil {
+		t.Error("Expected an error for 10.0.0.0/33, got nil instead.")
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package cidr2ip
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ParseError reports an entry that is not a valid CIDR notation, range or
+// address. Every function of the package that parses its input returns one,
+// which errors.As recovers however the error was wrapped since.
+type ParseError struct {
+	// CIDR is the entry as it was given.
+	CIDR string
+	// Line is the 1-based position of the entry in the list it came from,
+	// such as its line in the file the list was read from, or 0 when the
+	// function took a single entry.
+	Line int
+	// Err is the reason the entry was rejected.
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// atLine returns err with the Line of its ParseError set to the position of
+// the ith entry of a list. Any other error is returned as is.
+func atLine(err error, i int) error {
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		return err
+	}
+
+	located := *pe
+	located.Line = i + 1
+	return &located
+}
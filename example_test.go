@@ -0,0 +1,92 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package cidr2ip_test
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/rcmelendez/cidr2ip"
+)
+
+func ExampleExpandCIDR() {
+	ips, err := cidr2ip.ExpandCIDR("192.168.1.0/30")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	fmt.Println(ips)
+	// Output: [192.168.1.0 192.168.1.1 192.168.1.2 192.168.1.3]
+}
+
+func ExampleEachAddr() {
+	var last netip.Addr
+	err := cidr2ip.EachAddr("192.168.1.0/24", func(addr netip.Addr) error {
+		last = addr
+		return nil
+	})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	fmt.Println(last)
+	// Output: 192.168.1.255
+}
+
+func ExampleCountCIDR() {
+	count, err := cidr2ip.CountCIDR("2001:db8::/64")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	fmt.Println(count)
+	// Output: 18446744073709551616
+}
+
+func ExampleCIDRSeq() {
+	for addr, err := range cidr2ip.CIDRSeq("10.0.0.0/8") {
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		if addr.As4()[3] == 3 {
+			break
+		}
+		fmt.Println(addr)
+	}
+	// Output:
+	// 10.0.0.0
+	// 10.0.0.1
+	// 10.0.0.2
+}
+
+func ExampleEachIP() {
+	count := 0
+	err := cidr2ip.EachIP("10.0.0.0/8", func(ip net.IP) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	fmt.Println(count)
+	// Output: 16777216
+}
+
+func ExampleCollapseIPs() {
+	cidrs, err := cidr2ip.CollapseIPs([]string{"10.0.0.3", "10.0.0.0", "10.0.0.1", "10.0.0.2", "10.0.0.9"})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	fmt.Println(cidrs)
+	// Output: [10.0.0.0/30 10.0.0.9/32]
+}
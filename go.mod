@@ -1,3 +1,3 @@
 module github.com/rcmelendez/cidr2ip
 
-go 1.20
+go 1.23
@@ -0,0 +1,138 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package cidr2ip
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"sort"
+)
+
+// MergeCIDRs aggregates cidrs into the smallest set of CIDRs covering the
+// same addresses: entries are sorted by network, subnets are absorbed by the
+// blocks that contain them, and adjacent or overlapping blocks are combined
+// wherever the result is still a valid CIDR. IPv4 blocks come before IPv6.
+// Ranges are accepted as input and converted to CIDRs.
+func MergeCIDRs(cidrs []string) ([]string, error) {
+	ranges, err := parseRanges(cidrs)
+	if err != nil {
+		return nil, err
+	}
+
+	var merged []string
+	for _, r := range mergeRanges(ranges) {
+		merged = append(merged, r.cidrs()...)
+	}
+
+	return merged, nil
+}
+
+// CollapseIPs is the reverse of ExpandCIDRs: it returns the smallest set of
+// CIDRs covering exactly the addresses in ips, sorted as by MergeCIDRs. A full
+// run of addresses such as 10.0.0.0 to 10.0.0.255 becomes 10.0.0.0/24, and an
+// isolated address becomes a /32, or a /128 for IPv6. Every entry must be a
+// single address; duplicates are allowed.
+func CollapseIPs(ips []string) ([]string, error) {
+	for i, ip := range ips {
+		if addr, err := netip.ParseAddr(ip); err != nil || addr.Zone() != "" {
+			return nil, &ParseError{CIDR: ip, Line: i + 1, Err: fmt.Errorf("invalid IP address: %s", ip)}
+		}
+	}
+
+	return MergeCIDRs(ips)
+}
+
+// DedupCIDRs rewrites cidrs so no address is covered more than once while
+// keeping the input order: each entry loses the parts already covered by an
+// earlier one, and entries left empty are dropped. Entries that lose nothing
+// keep their original notation; the rest are replaced by the ranges that
+// remain. Only ranges are tracked, never individual addresses, so memory use
+// depends on the number of entries rather than their size.
+func DedupCIDRs(cidrs []string) ([]string, error) {
+	ranges, err := parseRanges(cidrs)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		deduped []string
+		covered []ipRange
+	)
+
+	for i, r := range ranges {
+		pieces := subtractRanges(r, covered)
+		if len(pieces) == 1 && pieces[0].first.Equal(r.first) && pieces[0].last.Equal(r.last) {
+			deduped = append(deduped, cidrs[i])
+		} else {
+			for _, p := range pieces {
+				deduped = append(deduped, p.String())
+			}
+		}
+
+		covered = mergeRanges(append(covered, r))
+	}
+
+	return deduped, nil
+}
+
+// FindOverlaps returns every pair of entries of cidrs that share at least one
+// address, whether one contains the other or they merely intersect. Each pair
+// holds the earlier entry first, and pairs are sorted by input position.
+func FindOverlaps(cidrs []string) ([][2]string, error) {
+	ranges, err := parseRanges(cidrs)
+	if err != nil {
+		return nil, err
+	}
+
+	order := make([]int, len(ranges))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ra, rb := ranges[order[a]], ranges[order[b]]
+		if len(ra.first) != len(rb.first) {
+			return len(ra.first) < len(rb.first)
+		}
+		return bytes.Compare(ra.first, rb.first) < 0
+	})
+
+	// Sweep in address order, keeping the ranges that may still reach the
+	// current one; anything ending before it can't overlap what follows
+	var (
+		pairs  [][2]int
+		active []int
+	)
+	for _, i := range order {
+		r := ranges[i]
+		kept := active[:0]
+		for _, j := range active {
+			a := ranges[j]
+			if len(a.first) != len(r.first) || bytes.Compare(a.last, r.first) < 0 {
+				continue
+			}
+			kept = append(kept, j)
+			if j < i {
+				pairs = append(pairs, [2]int{j, i})
+			} else {
+				pairs = append(pairs, [2]int{i, j})
+			}
+		}
+		active = append(kept, i)
+	}
+
+	sort.Slice(pairs, func(a, b int) bool {
+		if pairs[a][0] != pairs[b][0] {
+			return pairs[a][0] < pairs[b][0]
+		}
+		return pairs[a][1] < pairs[b][1]
+	})
+
+	overlaps := make([][2]string, len(pairs))
+	for k, p := range pairs {
+		overlaps[k] = [2]string{cidrs[p[0]], cidrs[p[1]]}
+	}
+
+	return overlaps, nil
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package cidr2ip
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMergeCIDRs(t *testing.T) {
+	tests := []struct {
+		cidrs    []string
+		expected []string
+	}{
+		// Two adjacent /25s merge into one /24
+		{[]string{"10.0.0.128/25", "10.0.0.0/25"}, []string{"10.0.0.0/24"}},
+		// Subnets are absorbed by the block that contains them
+		{[]string{"10.0.0.0/24", "10.0.0.64/26", "10.0.0.7/32"}, []string{"10.0.0.0/24"}},
+		// Adjacent blocks that don't form an aligned CIDR stay separate
+		{[]string{"10.0.1.0/24", "10.0.2.0/24"}, []string{"10.0.1.0/24", "10.0.2.0/24"}},
+		// Four /24s combine into a /22
+		{[]string{"10.0.3.0/24", "10.0.1.0/24", "10.0.0.0/24", "10.0.2.0/24"}, []string{"10.0.0.0/22"}},
+		// Ranges are converted to the CIDRs covering them
+		{[]string{"10.0.0.1-10.0.0.6"}, []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6/32"}},
+		// IPv4 comes before IPv6 and families never merge
+		{[]string{"2001:db8::/33", "10.0.0.0/8", "2001:db8:8000::/33"}, []string{"10.0.0.0/8", "2001:db8::/32"}},
+		{[]string{"0.0.0.0/1", "128.0.0.0/1"}, []string{"0.0.0.0/0"}},
+		{[]string{"255.255.255.0/24", "255.255.255.255/32"}, []string{"255.255.255.0/24"}},
+	}
+
+	for _, tt := range tests {
+		merged, err := MergeCIDRs(tt.cidrs)
+		if err != nil {
+			t.Fatalf("MergeCIDRs(%v) failed with error: %v", tt.cidrs, err)
+		}
+		if strings.Join(merged, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("MergeCIDRs(%v): expected %v, got %v instead.", tt.cidrs, tt.expected, merged)
+		}
+	}
+
+	// Test with an invalid CIDR
+	if _, err := MergeCIDRs([]string{"10.0.0.0/24", "10.0.0.0/33"}); err == nil {
+		t.Error("Expected an error, but MergeCIDRs succeeded.")
+	}
+}
+
+func TestDedupCIDRs(t *testing.T) {
+	cidrs := []string{"10.0.0.0/30", "10.0.0.0/31", "10.0.0.2-10.0.0.5", "192.168.0.0/31", "10.0.0.8/29", "10.0.0.0/28"}
+
+	deduped, err := DedupCIDRs(cidrs)
+	if err != nil {
+		t.Fatalf("DedupCIDRs failed with error: %v", err)
+	}
+
+	expected := []string{"10.0.0.0/30", "10.0.0.4-10.0.0.5", "192.168.0.0/31", "10.0.0.8/29", "10.0.0.6-10.0.0.7"}
+	if strings.Join(deduped, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected %v, got %v instead.", expected, deduped)
+	}
+}
+
+func TestFindOverlaps(t *testing.T) {
+	tests := []struct {
+		cidrs    []string
+		expected string
+	}{
+		{[]string{"10.0.0.0/24", "10.0.0.0/25"}, "[[10.0.0.0/24 10.0.0.0/25]]"},
+		// Touching blocks don't overlap, intersecting ranges do
+		{[]string{"10.0.0.0/25", "10.0.0.128/25"}, "[]"},
+		{[]string{"10.0.0.100-10.0.0.200", "10.0.0.0/25"}, "[[10.0.0.100-10.0.0.200 10.0.0.0/25]]"},
+		// Every pair is reported, the earlier entry first
+		{[]string{"10.0.0.64/26", "192.168.0.0/16", "10.0.0.0/24", "10.0.0.0/8"},
+			"[[10.0.0.64/26 10.0.0.0/24] [10.0.0.64/26 10.0.0.0/8] [10.0.0.0/24 10.0.0.0/8]]"},
+		// Families never overlap
+		{[]string{"0.0.0.0/0", "::/0"}, "[]"},
+	}
+
+	for _, tt := range tests {
+		overlaps, err := FindOverlaps(tt.cidrs)
+		if err != nil {
+			t.Fatalf("FindOverlaps(%v) failed with error: %v", tt.cidrs, err)
+		}
+		if got := fmt.Sprint(overlaps); got != tt.expected {
+			t.Errorf("FindOverlaps(%v): expected %s, got %s instead.", tt.cidrs, tt.expected, got)
+		}
+	}
+
+	// Test with an invalid CIDR
+	if _, err := FindOverlaps([]string{"10.0.0.0/24", "10.0.0.0/33"}); err == nil {
+		t.Error("Expected an error, but FindOverlaps succeeded.")
+	}
+}
+
+func TestCollapseIPs(t *testing.T) {
+	// Test that a full /24 of addresses, in any order, collapses to one block
+	var ips []string
+	for i := 255; i >= 0; i-- {
+		ips = append(ips, fmt.Sprintf("192.168.7.%d", i))
+	}
+	collapsed, err := CollapseIPs(ips)
+	if err != nil {
+		t.Fatalf("CollapseIPs failed with error: %v", err)
+	}
+	if strings.Join(collapsed, " ") != "192.168.7.0/24" {
+		t.Errorf("Expected [192.168.7.0/24], got %v instead.", collapsed)
+	}
+
+	tests := []struct {
+		ips      []string
+		expected []string
+	}{
+		// Isolated addresses stay single hosts
+		{[]string{"10.0.0.9", "10.0.0.1"}, []string{"10.0.0.1/32", "10.0.0.9/32"}},
+		// Duplicates are covered once, and aligned pairs combine
+		{[]string{"10.0.0.3", "10.0.0.2", "10.0.0.2", "10.0.0.4"}, []string{"10.0.0.2/31", "10.0.0.4/32"}},
+		{[]string{"2001:db8::1", "10.0.0.0"}, []string{"10.0.0.0/32", "2001:db8::1/128"}},
+	}
+
+	for _, tt := range tests {
+		collapsed, err := CollapseIPs(tt.ips)
+		if err != nil {
+			t.Fatalf("CollapseIPs(%v) failed with error: %v", tt.ips, err)
+		}
+		if strings.Join(collapsed, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("CollapseIPs(%v): expected %v, got %v instead.", tt.ips, tt.expected, collapsed)
+		}
+	}
+
+	// Test that anything but a single address is rejected
+	for _, ip := range []string{"10.0.0.0/24", "10.0.0.1-10.0.0.2", "10.0.0.256"} {
+		if _, err := CollapseIPs([]string{ip}); err == nil {
+			t.Errorf("CollapseIPs(%s): expected an error, but it succeeded.", ip)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package cidr2ip
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+)
+
+// NetworkAddr returns the first address of ipnet, its network address. The
+// result is nil if the IP and mask of ipnet are of different lengths that
+// can't be reconciled.
+func NetworkAddr(ipnet *net.IPNet) net.IP {
+	return ipnet.IP.Mask(ipnet.Mask)
+}
+
+// BroadcastAddr returns the last address of ipnet, which for IPv4 is its
+// broadcast address. IPv6 has no broadcast, but the last address is returned
+// just the same. The result is nil when NetworkAddr's would be.
+func BroadcastAddr(ipnet *net.IPNet) net.IP {
+	network := NetworkAddr(ipnet)
+	if network == nil {
+		return nil
+	}
+
+	// Mask trims a 16-byte mask of an IPv4 network to its last 4 bytes
+	mask := ipnet.Mask[len(ipnet.Mask)-len(network):]
+	broadcast := make(net.IP, len(network))
+	for i := range network {
+		broadcast[i] = network[i] | ^mask[i]
+	}
+
+	return broadcast
+}
+
+// WildcardMask returns the inverse of the mask of ipnet, as used by ACLs:
+// 0.0.0.255 for a /24.
+func WildcardMask(ipnet *net.IPNet) net.IP {
+	wildcard := make(net.IP, len(ipnet.Mask))
+	for i, b := range ipnet.Mask {
+		wildcard[i] = ^b
+	}
+
+	return wildcard
+}
+
+// AddrAtOffset returns the address n positions after the network address of
+// ipnet, so an offset of 0 is the network address itself. It fails if n is
+// negative or past the last address of ipnet. A big.Int is used because IPv6
+// offsets overflow any int.
+func AddrAtOffset(ipnet *net.IPNet, n *big.Int) (netip.Addr, error) {
+	first, last := NetworkAddr(ipnet), BroadcastAddr(ipnet)
+	if first == nil {
+		return netip.Addr{}, fmt.Errorf("invalid network: %s", ipnet)
+	}
+
+	addr := new(big.Int).Add(new(big.Int).SetBytes(first), n)
+	if n.Sign() < 0 || addr.Cmp(new(big.Int).SetBytes(last)) > 0 {
+		return netip.Addr{}, fmt.Errorf("offset %s is outside %s", n, ipnet)
+	}
+
+	ip, _ := netip.AddrFromSlice(addr.FillBytes(make([]byte, len(first))))
+	return ip.Unmap(), nil
+}
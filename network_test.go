@@ -0,0 +1,98 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package cidr2ip
+
+import (
+	"math/big"
+	"net"
+	"testing"
+)
+
+func TestNetworkBroadcastAddr(t *testing.T) {
+	tests := []struct {
+		cidr               string
+		network, broadcast string
+	}{
+		{"192.168.1.77/24", "192.168.1.0", "192.168.1.255"},
+		{"10.0.0.0/8", "10.0.0.0", "10.255.255.255"},
+		{"10.0.0.1/31", "10.0.0.0", "10.0.0.1"},
+		{"10.0.0.7/32", "10.0.0.7", "10.0.0.7"},
+		{"2001:db8::1/64", "2001:db8::", "2001:db8::ffff:ffff:ffff:ffff"},
+	}
+
+	for _, tt := range tests {
+		_, ipnet, err := net.ParseCIDR(tt.cidr)
+		if err != nil {
+			t.Fatalf("ParseCIDR(%s) failed with error: %v", tt.cidr, err)
+		}
+		if got := NetworkAddr(ipnet); got.String() != tt.network {
+			t.Errorf("NetworkAddr(%s): expected %s, got %s instead.", tt.cidr, tt.network, got)
+		}
+		if got := BroadcastAddr(ipnet); got.String() != tt.broadcast {
+			t.Errorf("BroadcastAddr(%s): expected %s, got %s instead.", tt.cidr, tt.broadcast, got)
+		}
+	}
+
+	// Test an IPv4 address held in 16 bytes with a 4-byte mask
+	ipnet := &net.IPNet{IP: net.ParseIP("172.16.5.4"), Mask: net.CIDRMask(12, 32)}
+	if got := BroadcastAddr(ipnet); got.String() != "172.31.255.255" {
+		t.Errorf("Expected 172.31.255.255, got %s instead.", got)
+	}
+}
+
+func TestWildcardMask(t *testing.T) {
+	tests := []struct {
+		cidr     string
+		expected string
+	}{
+		{"10.0.0.0/16", "0.0.255.255"},
+		{"192.168.1.0/24", "0.0.0.255"},
+		{"192.168.1.4/30", "0.0.0.3"},
+		{"10.0.0.1/32", "0.0.0.0"},
+		{"2001:db8::/48", "::ffff:ffff:ffff:ffff:ffff"},
+	}
+
+	for _, tt := range tests {
+		_, ipnet, err := net.ParseCIDR(tt.cidr)
+		if err != nil {
+			t.Fatalf("ParseCIDR(%s) failed with error: %v", tt.cidr, err)
+		}
+		if got := WildcardMask(ipnet); got.String() != tt.expected {
+			t.Errorf("WildcardMask(%s): expected %s, got %s instead.", tt.cidr, tt.expected, got)
+		}
+	}
+}
+
+func TestAddrAtOffset(t *testing.T) {
+	tests := []struct {
+		cidr     string
+		offset   int64
+		expected string
+	}{
+		{"192.168.1.0/24", 0, "192.168.1.0"},
+		{"192.168.1.77/24", 253, "192.168.1.253"},
+		{"192.168.1.0/24", 255, "192.168.1.255"},
+		{"10.0.0.0/8", 65536, "10.1.0.0"},
+		{"2001:db8::/64", 1 << 40, "2001:db8::100:0:0"},
+	}
+
+	for _, tt := range tests {
+		_, ipnet, _ := net.ParseCIDR(tt.cidr)
+		addr, err := AddrAtOffset(ipnet, big.NewInt(tt.offset))
+		if err != nil {
+			t.Fatalf("AddrAtOffset(%s, %d) failed with error: %v", tt.cidr, tt.offset, err)
+		}
+		if addr.String() != tt.expected {
+			t.Errorf("AddrAtOffset(%s, %d): expected %s, got %s instead.", tt.cidr, tt.offset, tt.expected, addr)
+		}
+	}
+
+	// Test with offsets outside the network
+	_, ipnet, _ := net.ParseCIDR("192.168.1.0/24")
+	for _, offset := range []int64{-1, 256} {
+		if _, err := AddrAtOffset(ipnet, big.NewInt(offset)); err == nil {
+			t.Errorf("AddrAtOffset(%s, %d): expected an error, but it succeeded.", ipnet, offset)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package cidr2ip
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+)
+
+// ipRange is an inclusive range of addresses of a single family.
+type ipRange struct {
+	first, last net.IP
+}
+
+func (r ipRange) String() string {
+	return fmt.Sprintf("%s-%s", r.first, r.last)
+}
+
+// subtractRanges returns the parts of r not covered by any of the sorted,
+// disjoint ranges in covered.
+func subtractRanges(r ipRange, covered []ipRange) []ipRange {
+	var pieces []ipRange
+
+	cur := r.first
+	for _, c := range covered {
+		if len(c.first) != len(r.first) || bytes.Compare(c.last, cur) < 0 {
+			continue
+		}
+		if bytes.Compare(c.first, r.last) > 0 {
+			break
+		}
+
+		if bytes.Compare(c.first, cur) > 0 {
+			pieces = append(pieces, ipRange{cur, prevIP(c.first)})
+		}
+		if bytes.Compare(c.last, r.last) >= 0 {
+			return pieces
+		}
+		cur = nextIPCopy(c.last)
+	}
+
+	return append(pieces, ipRange{cur, r.last})
+}
+
+// mergeRanges sorts ranges and joins the ones that overlap or touch.
+func mergeRanges(ranges []ipRange) []ipRange {
+	sort.Slice(ranges, func(i, j int) bool {
+		if len(ranges[i].first) != len(ranges[j].first) {
+			return len(ranges[i].first) < len(ranges[j].first)
+		}
+		return bytes.Compare(ranges[i].first, ranges[j].first) < 0
+	})
+
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 {
+			prev := &merged[n-1]
+			// Nothing sorts after the last address of a family, so it absorbs everything
+			touches := isLastIP(prev.last) || bytes.Compare(nextIPCopy(prev.last), r.first) >= 0
+			if len(prev.last) == len(r.first) && touches {
+				if bytes.Compare(r.last, prev.last) > 0 {
+					prev.last = r.last
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+// parseRanges parses every entry of cidrs into an ipRange. An invalid entry
+// fails with a *ParseError giving its position in cidrs.
+func parseRanges(cidrs []string) ([]ipRange, error) {
+	ranges := make([]ipRange, 0, len(cidrs))
+	for i, cidr := range cidrs {
+		first, last, err := ParseRange(cidr)
+		if err != nil {
+			return nil, atLine(err, i)
+		}
+		ranges = append(ranges, ipRange{first, last})
+	}
+
+	return ranges, nil
+}
+
+// cidrs returns the smallest list of CIDRs that covers exactly r.
+func (r ipRange) cidrs() []string {
+	var (
+		cidrs []string
+		bits  = len(r.first) * 8
+		start = new(big.Int).SetBytes(r.first)
+		end   = new(big.Int).SetBytes(r.last)
+		one   = big.NewInt(1)
+	)
+
+	for start.Cmp(end) <= 0 {
+		// Grow the block as far as the alignment of start and the end allow
+		hostBits := bits
+		if start.Sign() != 0 {
+			hostBits = int(start.TrailingZeroBits())
+		}
+
+		blockEnd := new(big.Int)
+		for ; ; hostBits-- {
+			blockEnd.Lsh(one, uint(hostBits)).Add(blockEnd, start).Sub(blockEnd, one)
+			if blockEnd.Cmp(end) <= 0 {
+				break
+			}
+		}
+
+		ip := net.IP(start.FillBytes(make([]byte, len(r.first))))
+		cidrs = append(cidrs, fmt.Sprintf("%s/%d", ip, bits-hostBits))
+		start.Add(blockEnd, one)
+	}
+
+	return cidrs
+}
+
+// nextIPCopy returns the address after ip without modifying ip.
+func nextIPCopy(ip net.IP) net.IP {
+	next := append(net.IP(nil), ip...)
+	nextIP(next)
+	return next
+}
+
+// prevIP returns the address before ip without modifying ip.
+func prevIP(ip net.IP) net.IP {
+	prev := append(net.IP(nil), ip...)
+	for i := len(prev) - 1; i >= 0; i-- {
+		prev[i]--
+		if prev[i] < 255 {
+			break
+		}
+	}
+
+	return prev
+}
+
+// isLastIP reports whether ip is the highest address of its family.
+func isLastIP(ip net.IP) bool {
+	for _, b := range ip {
+		if b != 255 {
+			return false
+		}
+	}
+
+	return true
+}
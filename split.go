@@ -0,0 +1,66 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package cidr2ip
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+)
+
+// maxSplitBits caps SplitCIDR at 2^24 subnets, the number of /24s in a /0.
+const maxSplitBits = 24
+
+// SplitCIDR returns the subnets of length newPrefix that make up cidr, in
+// ascending order. Splitting into the prefix length cidr already has returns
+// cidr itself in canonical form. A bare address or a range is split as the
+// block it covers, and rejected if no single block covers it exactly. It
+// fails if newPrefix is shorter than the prefix of cidr or longer than its
+// address family allows.
+func SplitCIDR(cidr string, newPrefix int) ([]string, error) {
+	ipnet, err := parseBlock(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	ones, bits := ipnet.Mask.Size()
+	if newPrefix < ones || newPrefix > bits {
+		return nil, fmt.Errorf("cannot split %s into /%d subnets", cidr, newPrefix)
+	}
+
+	if newPrefix-ones > maxSplitBits {
+		return nil, fmt.Errorf("splitting %s into /%d subnets yields more than 2^%d subnets", cidr, newPrefix, maxSplitBits)
+	}
+
+	var (
+		subnets = make([]string, 0, 1<<(newPrefix-ones))
+		network = new(big.Int).SetBytes(ipnet.IP)
+		step    = new(big.Int).Lsh(big.NewInt(1), uint(bits-newPrefix))
+	)
+
+	for i := 0; i < cap(subnets); i++ {
+		ip := net.IP(network.FillBytes(make([]byte, len(ipnet.IP))))
+		subnets = append(subnets, fmt.Sprintf("%s/%d", ip, newPrefix))
+		network.Add(network, step)
+	}
+
+	return subnets, nil
+}
+
+// parseBlock parses cidr, in any notation parseAddrRange accepts, into the
+// CIDR block covering exactly the same addresses.
+func parseBlock(cidr string) (*net.IPNet, error) {
+	first, last, err := parseAddrRange(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := ipRange{first.AsSlice(), last.AsSlice()}.cidrs()
+	if len(blocks) != 1 {
+		return nil, &ParseError{CIDR: cidr, Err: fmt.Errorf("%s is not a single CIDR block", cidr)}
+	}
+
+	_, ipnet, err := net.ParseCIDR(blocks[0])
+	return ipnet, err
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2023 Roberto Meléndez.
+// Licensed under the MIT License. See the LICENSE file in the project root for license information.
+
+package cidr2ip
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSplitCIDR(t *testing.T) {
+	// Test splitting a /16 into /24s
+	subnets, err := SplitCIDR("172.16.0.0/16", 24)
+	if err != nil {
+		t.Fatalf("SplitCIDR failed with error: %v", err)
+	}
+	if len(subnets) != 256 {
+		t.Fatalf("Expected 256 subnets, got %d instead.", len(subnets))
+	}
+	if subnets[0] != "172.16.0.0/24" || subnets[1] != "172.16.1.0/24" || subnets[255] != "172.16.255.0/24" {
+		t.Errorf("Unexpected subnets: %s, %s ... %s", subnets[0], subnets[1], subnets[255])
+	}
+
+	tests := []struct {
+		cidr      string
+		newPrefix int
+		expected  []string
+	}{
+		// Splitting into the same length returns the canonical CIDR
+		{"10.0.0.5/24", 24, []string{"10.0.0.0/24"}},
+		{"10.0.0.0/30", 32, []string{"10.0.0.0/32", "10.0.0.1/32", "10.0.0.2/32", "10.0.0.3/32"}},
+		{"2001:db8::/47", 48, []string{"2001:db8::/48", "2001:db8:1::/48"}},
+		// Bare addresses and ranges are split as the block they cover
+		{"10.0.0.5", 32, []string{"10.0.0.5/32"}},
+		{"10.0.0.0-10.0.0.3", 31, []string{"10.0.0.0/31", "10.0.0.2/31"}},
+		{"10.0.0.4+4", 30, []string{"10.0.0.4/30"}},
+	}
+
+	for _, tt := range tests {
+		subnets, err := SplitCIDR(tt.cidr, tt.newPrefix)
+		if err != nil {
+			t.Fatalf("SplitCIDR(%s, %d) failed with error: %v", tt.cidr, tt.newPrefix, err)
+		}
+		if strings.Join(subnets, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("SplitCIDR(%s, %d): expected %v, got %v instead.", tt.cidr, tt.newPrefix, tt.expected, subnets)
+		}
+	}
+
+	// Test with prefix lengths that can't be split into
+	for _, newPrefix := range []int{16, 33, -1} {
+		if _, err := SplitCIDR("10.0.0.0/24", newPrefix); err == nil {
+			t.Errorf("SplitCIDR(10.0.0.0/24, %d): expected an error, but it succeeded.", newPrefix)
+		}
+	}
+	if _, err := SplitCIDR("2001:db8::/32", 64); err == nil {
+		t.Error("Expected an error for too many subnets, but SplitCIDR succeeded.")
+	}
+
+	// Test that invalid entries and ranges of several blocks fail to parse
+	for _, cidr := range []string{"10.0.0.0/33", "10.0.0.1-10.0.0.5"} {
+		var pe *ParseError
+		if _, err := SplitCIDR(cidr, 32); !errors.As(err, &pe) || pe.CIDR != cidr {
+			t.Errorf("SplitCIDR(%s, 32): expected a *ParseError, got %v instead.", cidr, err)
+		}
+	}
+}